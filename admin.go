@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// previewHandler runs one gather and conversion cycle and returns the series
+// that would be sent, without sending them. The optional metric query
+// parameter is a regular expression the metric name has to fully match.
+func previewHandler(p *pipeline) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var re *regexp.Regexp
+		if expr := r.URL.Query().Get("metric"); expr != "" {
+			var err error
+			re, err = regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		samples, err := p.collect(true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if re != nil {
+			filtered := samples[:0]
+			for _, s := range samples {
+				if re.MatchString(labelValue(s.Labels, model.MetricNameLabel)) {
+					filtered = append(filtered, s)
+				}
+			}
+			samples = filtered
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(samples); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// memorySinkHandler returns the write requests kept by -sink=memory, oldest
+// first. With drain=true they're removed from the sink.
+func memorySinkHandler(s *MemorySink) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var requests []prompb.WriteRequest
+		if r.URL.Query().Get("drain") == "true" {
+			requests = s.Drain()
+		} else {
+			requests = s.Requests()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(requests); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+func labelValue(labels []prompb.Label, name string) string {
+	for _, l := range labels {
+		if l.Name == name {
+			return l.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestPreviewHandler(t *testing.T) {
+	defer func(prev bool) { emitTargetInfo = prev }(emitTargetInfo)
+	defer func(prev int) { maxTotalSeries = prev }(maxTotalSeries)
+	emitTargetInfo = false
+	maxTotalSeries = 2
+
+	dir, err := ioutil.TempDir("", "preview")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "labels.json")
+	writeLabelsFile(t, path, `{"cluster": "preview"}`, time.Now())
+	f, err := newExternalLabelsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := prometheus.NewRegistry()
+	for _, name := range []string{"test_kept_a", "test_kept_b", "test_other"} {
+		r.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: "test"}))
+	}
+	h := previewHandler(newPipeline(r, f))
+
+	preview := func(query string) []prompb.TimeSeries {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/preview"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: got status %d: %s", query, rec.Code, rec.Body)
+		}
+		var series []prompb.TimeSeries
+		if err := json.Unmarshal(rec.Body.Bytes(), &series); err != nil {
+			t.Fatal(err)
+		}
+		return series
+	}
+
+	before := metricValue(droppedOverCeiling)
+	all := preview("")
+	if len(all) != 2 {
+		t.Errorf("got %d series, want the 2 under -max-total-series", len(all))
+	}
+	for _, s := range all {
+		if v := labelValue(s.Labels, "cluster"); v != "preview" {
+			t.Errorf("series %s has cluster=%q, want the external label", seriesKey(s.Labels), v)
+		}
+	}
+	if got := metricValue(droppedOverCeiling) - before; got != 0 {
+		t.Errorf("preview counted %v series over the ceiling", got)
+	}
+
+	filtered := preview("?metric=test_kept_.*")
+	for _, s := range filtered {
+		if name := labelValue(s.Labels, "__name__"); name != "test_kept_a" && name != "test_kept_b" {
+			t.Errorf("filtered preview holds %s", name)
+		}
+	}
+	if len(filtered) == 0 {
+		t.Error("filtered preview is empty")
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/preview?metric=(", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid regular expression: got status %d, want 400", rec.Code)
+	}
+}
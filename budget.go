@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxDeferredBatches bounds how many batches are held back for a later
+// interval once the byte budget is exhausted.
+const maxDeferredBatches = 10
+
+var (
+	bytesSent = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "remote_write_bytes_sent_total",
+		Help: "Compressed bytes of successfully sent write requests",
+	})
+
+	bytesBudgetRemaining = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "remote_write_bytes_budget_remaining",
+		Help: "Compressed bytes that can still be sent in the current push interval",
+	})
+)
+
+// byteBudget limits the compressed bytes sent per push interval.
+type byteBudget struct {
+	max       int
+	remaining int
+}
+
+func newByteBudget(max int) *byteBudget {
+	b := &byteBudget{max: max}
+	b.reset()
+	return b
+}
+
+// reset starts a new interval.
+func (b *byteBudget) reset() {
+	b.remaining = b.max
+	bytesBudgetRemaining.Set(float64(b.remaining))
+}
+
+// take reserves n bytes, reporting whether they fit into the budget.
+func (b *byteBudget) take(n int) bool {
+	if n > b.remaining {
+		return false
+	}
+	b.remaining -= n
+	bytesBudgetRemaining.Set(float64(b.remaining))
+	return true
+}
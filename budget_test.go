@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestByteBudgetDefersBatches(t *testing.T) {
+	var pending []batch
+	for i := 0; i < 3; i++ {
+		b, err := newBatch(testSeries(10, "budget"), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending = append(pending, b)
+	}
+	size := len(pending[0].data)
+	budget := newByteBudget(2*size + size/2)
+	cl := newRecordingClient()
+	p := newPipeline(prometheus.NewRegistry(), nil)
+	send := func() {
+		pending = sendBatches(context.Background(), cl, p, budget, pending, time.Now().Add(time.Minute), make(chan struct{}))
+	}
+
+	sentBefore := metricValue(bytesSent)
+	send()
+	if len(cl.stores) != 2 || len(pending) != 1 {
+		t.Fatalf("first interval: sent %d batches and deferred %d, want 2 and 1", len(cl.stores), len(pending))
+	}
+	if got := metricValue(bytesBudgetRemaining); got != float64(size/2) {
+		t.Errorf("remaining budget = %v, want %v", got, size/2)
+	}
+	if got := metricValue(bytesSent) - sentBefore; got != float64(2*size) {
+		t.Errorf("counted %v bytes sent, want %v", got, 2*size)
+	}
+
+	// Nothing more goes out until the next interval.
+	send()
+	if len(cl.stores) != 2 {
+		t.Errorf("sent %d batches over budget", len(cl.stores)-2)
+	}
+
+	budget.reset()
+	send()
+	if len(cl.stores) != 3 || len(pending) != 0 {
+		t.Errorf("next interval: sent %d batches in total with %d deferred, want 3 and 0", len(cl.stores), len(pending))
+	}
+}
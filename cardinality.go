@@ -0,0 +1,97 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// maxTrackedLabelValues bounds the values remembered per label name. A label
+// reaching it is reported with that cardinality.
+const maxTrackedLabelValues = 10000
+
+var labelValueCardinality = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "remote_write_label_value_cardinality",
+	Help: "Distinct values seen per label name over the last -label-cardinality-window, for labels over -label-cardinality-min",
+}, []string{"label"})
+
+// labelCardinality counts the distinct values of every label name over a
+// sliding window. Values are kept for the current window and the previous
+// one, and a label's cardinality is counted over both, so it never drops to
+// zero when a window starts over.
+type labelCardinality struct {
+	min    int
+	window time.Duration
+
+	mu       sync.Mutex
+	start    time.Time
+	values   map[string]map[string]struct{}
+	previous map[string]map[string]struct{}
+}
+
+func newLabelCardinality(min int, window time.Duration) *labelCardinality {
+	return &labelCardinality{
+		min:      min,
+		window:   window,
+		start:    time.Now(),
+		values:   map[string]map[string]struct{}{},
+		previous: map[string]map[string]struct{}{},
+	}
+}
+
+func (c *labelCardinality) observe(series []prompb.TimeSeries) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch elapsed := time.Since(c.start); {
+	case elapsed >= 2*c.window:
+		// Nothing was observed during the previous window.
+		c.start = time.Now()
+		c.previous = map[string]map[string]struct{}{}
+		c.values = map[string]map[string]struct{}{}
+	case elapsed >= c.window:
+		c.start = c.start.Add(c.window)
+		c.previous = c.values
+		c.values = map[string]map[string]struct{}{}
+	}
+
+	for _, s := range series {
+		for _, l := range s.Labels {
+			values, ok := c.values[l.Name]
+			if !ok {
+				values = map[string]struct{}{}
+				c.values[l.Name] = values
+			}
+			if len(values) < maxTrackedLabelValues {
+				values[l.Value] = struct{}{}
+			}
+		}
+	}
+
+	labelValueCardinality.Reset()
+	for _, names := range []map[string]map[string]struct{}{c.values, c.previous} {
+		for name := range names {
+			if n := c.cardinality(name); n >= c.min {
+				labelValueCardinality.WithLabelValues(name).Set(float64(n))
+			}
+		}
+	}
+}
+
+// cardinality returns the distinct values of name over the current and the
+// previous window, up to maxTrackedLabelValues.
+func (c *labelCardinality) cardinality(name string) int {
+	values := c.values[name]
+	n := len(values)
+	for v := range c.previous[name] {
+		if n >= maxTrackedLabelValues {
+			break
+		}
+		if _, ok := values[v]; !ok {
+			n++
+		}
+	}
+	return n
+}
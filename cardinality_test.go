@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func cardinalitySeries(label string, values ...int) []prompb.TimeSeries {
+	var series []prompb.TimeSeries
+	for _, v := range values {
+		series = append(series, prompb.TimeSeries{Labels: []prompb.Label{
+			{Name: "__name__", Value: "m"},
+			{Name: label, Value: fmt.Sprint(v)},
+		}})
+	}
+	return series
+}
+
+// reportedCardinality returns the gauge values currently exported.
+func reportedCardinality(t *testing.T) map[string]float64 {
+	ch := make(chan prometheus.Metric, 100)
+	labelValueCardinality.Collect(ch)
+	close(ch)
+	got := map[string]float64{}
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		got[pb.Label[0].GetValue()] = pb.Gauge.GetValue()
+	}
+	return got
+}
+
+func TestLabelCardinalityReportsHighCardinality(t *testing.T) {
+	c := newLabelCardinality(3, time.Hour)
+	c.observe(append(cardinalitySeries("user", 1, 2, 3, 4), cardinalitySeries("env", 1)...))
+
+	got := reportedCardinality(t)
+	if got["user"] != 4 {
+		t.Errorf("user: got %v, want 4", got["user"])
+	}
+	if _, ok := got["env"]; ok {
+		t.Error("env is reported under -label-cardinality-min")
+	}
+}
+
+func TestLabelCardinalitySlidingWindow(t *testing.T) {
+	c := newLabelCardinality(1, time.Minute)
+	c.observe(cardinalitySeries("user", 1, 2, 3))
+
+	// The previous window still counts once a new one starts.
+	c.start = c.start.Add(-time.Minute)
+	c.observe(cardinalitySeries("user", 3, 4))
+	if got := reportedCardinality(t)["user"]; got != 4 {
+		t.Errorf("after one window: got %v, want 4", got)
+	}
+
+	// Values seen only two windows ago are forgotten.
+	c.start = c.start.Add(-time.Minute)
+	c.observe(cardinalitySeries("user", 5))
+	if got := reportedCardinality(t)["user"]; got != 3 {
+		t.Errorf("after two windows: got %v, want 3", got)
+	}
+
+	// Nothing is kept after a gap of two windows.
+	c.start = c.start.Add(-2 * time.Minute)
+	c.observe(cardinalitySeries("user", 6))
+	if got := reportedCardinality(t)["user"]; got != 1 {
+		t.Errorf("after a gap: got %v, want 1", got)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var droppedOverCeiling = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "remote_write_dropped_over_ceiling_total",
+	Help: "Series dropped because a push exceeded -max-total-series",
+})
+
+// seriesCeiling caps the number of series sent per push. The kept series are
+// the ones with the lowest label hashes, so the same subset is sent on every
+// push as long as the series themselves don't change.
+type seriesCeiling struct {
+	max int
+
+	mu   sync.Mutex
+	over bool
+}
+
+// apply keeps at most max series. A dry run neither logs nor counts the
+// dropped series.
+func (c *seriesCeiling) apply(series []prompb.TimeSeries, dryRun bool) []prompb.TimeSeries {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(series) <= c.max {
+		if c.over && !dryRun {
+			log.Printf("series count %d is back under -max-total-series=%d", len(series), c.max)
+			c.over = false
+		}
+		return series
+	}
+	if !dryRun {
+		if !c.over {
+			log.Printf("series count %d exceeds -max-total-series=%d, dropping the remainder", len(series), c.max)
+		}
+		c.over = true
+		droppedOverCeiling.Add(float64(len(series) - c.max))
+	}
+
+	hashes := make([]uint64, len(series))
+	idx := make([]int, len(series))
+	for i, s := range series {
+		hashes[i] = seriesHash(s.Labels)
+		idx[i] = i
+	}
+	sort.Slice(idx, func(a, b int) bool {
+		return hashes[idx[a]] < hashes[idx[b]]
+	})
+
+	keep := idx[:c.max]
+	// Preserve the original order of the kept series.
+	sort.Ints(keep)
+	out := make([]prompb.TimeSeries, 0, c.max)
+	for _, i := range keep {
+		out = append(out, series[i])
+	}
+	return out
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func keptKeys(t *testing.T, c *seriesCeiling, n int, seed int64, dryRun bool) []string {
+	series := testSeries(n, "ceiling")
+	rand.New(rand.NewSource(seed)).Shuffle(len(series), func(i, j int) {
+		series[i], series[j] = series[j], series[i]
+	})
+	var keys []string
+	for _, s := range c.apply(series, dryRun) {
+		keys = append(keys, seriesKey(s.Labels))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestSeriesCeilingStableSelection(t *testing.T) {
+	c := &seriesCeiling{max: 10}
+
+	before := metricValue(droppedOverCeiling)
+	first := keptKeys(t, c, 25, 1, false)
+	if len(first) != 10 {
+		t.Fatalf("kept %d series, want 10", len(first))
+	}
+	if got := metricValue(droppedOverCeiling) - before; got != 15 {
+		t.Errorf("counted %v dropped series, want 15", got)
+	}
+	if !c.over {
+		t.Error("ceiling not marked as exceeded")
+	}
+
+	// The same subset is kept whatever the order of the series.
+	for seed := int64(2); seed < 5; seed++ {
+		got := keptKeys(t, c, 25, seed, false)
+		if len(got) != len(first) {
+			t.Fatalf("push %d kept %d series, want %d", seed, len(got), len(first))
+		}
+		for i := range got {
+			if got[i] != first[i] {
+				t.Fatalf("push %d kept %v, want %v", seed, got, first)
+			}
+		}
+	}
+
+	// A dry run under the ceiling leaves the state alone.
+	before = metricValue(droppedOverCeiling)
+	if got := keptKeys(t, c, 5, 1, true); len(got) != 5 {
+		t.Errorf("kept %d series under the ceiling, want 5", len(got))
+	}
+	if !c.over {
+		t.Error("dry run reset the exceeded state")
+	}
+	keptKeys(t, c, 25, 1, true)
+	if got := metricValue(droppedOverCeiling) - before; got != 0 {
+		t.Errorf("dry runs counted %v dropped series", got)
+	}
+}
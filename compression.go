@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	compressionErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "remote_write_compression_errors_total",
+		Help: "Failed compressions and decompressions, by operation and codec",
+	}, []string{"op", "codec"})
+
+	compressionRatio = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "remote_write_compression_ratio",
+		Help: "Uncompressed divided by compressed size of the last write request",
+	})
+)
+
+// codec compresses write request bodies.
+type codec struct {
+	name string
+	// minLevel and maxLevel bound the compression levels the codec
+	// supports. Codecs without levels only accept 0.
+	minLevel, maxLevel int
+	encode             func(src []byte) ([]byte, error)
+}
+
+var snappyCodec = codec{
+	name: "snappy",
+	encode: func(src []byte) ([]byte, error) {
+		return snappy.Encode(nil, src), nil
+	},
+}
+
+// validateLevel checks that the codec supports the compression level.
+func (c codec) validateLevel(level int) error {
+	if level < c.minLevel || level > c.maxLevel {
+		if c.minLevel == c.maxLevel {
+			return fmt.Errorf("%s has no compression levels, got %d", c.name, level)
+		}
+		return fmt.Errorf("%s compression level must be between %d and %d, got %d", c.name, c.minLevel, c.maxLevel, level)
+	}
+	return nil
+}
+
+// requestCodec is the codec request bodies are compressed with.
+var requestCodec = snappyCodec
+
+// compress compresses data with requestCodec, counting failures.
+func compress(data []byte) ([]byte, error) {
+	compressed, err := requestCodec.encode(data)
+	if err != nil {
+		compressionErrors.WithLabelValues("encode", requestCodec.name).Inc()
+		return nil, fmt.Errorf("%s compression failed: %v", requestCodec.name, err)
+	}
+	return compressed, nil
+}
+
+// observeCompressionRatio records the compression ratio of an outgoing batch.
+func observeCompressionRatio(uncompressed, compressed int) {
+	if compressed > 0 {
+		compressionRatio.Set(float64(uncompressed) / float64(compressed))
+	}
+}
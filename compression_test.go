@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestCompressionFailureSkipsBatch(t *testing.T) {
+	defer func(prev codec) { requestCodec = prev }(requestCodec)
+	requestCodec = codec{
+		name: "failing",
+		encode: func([]byte) ([]byte, error) {
+			return nil, errors.New("out of entropy")
+		},
+	}
+	defer func(prev bool) { emitTargetInfo = prev }(emitTargetInfo)
+	emitTargetInfo = false
+
+	r := prometheus.NewRegistry()
+	r.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_gauge", Help: "test"}))
+
+	errorsBefore := metricValue(compressionErrors.WithLabelValues("encode", "failing"))
+	droppedBefore := metricValue(droppedSamples.WithLabelValues(dropReasonEncode))
+	batches, err := nextBatches(newPipeline(r, nil))
+	if err == nil {
+		t.Error("got no error")
+	}
+	if len(batches) != 0 {
+		t.Errorf("got %d batches, want the failed one skipped", len(batches))
+	}
+	if got := metricValue(compressionErrors.WithLabelValues("encode", "failing")) - errorsBefore; got != 1 {
+		t.Errorf("counted %v encode errors, want 1", got)
+	}
+	if got := metricValue(droppedSamples.WithLabelValues(dropReasonEncode)) - droppedBefore; got != 1 {
+		t.Errorf("counted %v samples dropped, want 1", got)
+	}
+}
+
+func TestValidateLevel(t *testing.T) {
+	if err := snappyCodec.validateLevel(0); err != nil {
+		t.Errorf("snappy level 0: %v", err)
+	}
+	if err := snappyCodec.validateLevel(3); err == nil {
+		t.Error("snappy accepted level 3")
+	}
+
+	leveled := codec{name: "leveled", minLevel: 1, maxLevel: 19}
+	for level, valid := range map[int]bool{0: false, 1: true, 19: true, 20: false} {
+		if err := leveled.validateLevel(level); (err == nil) != valid {
+			t.Errorf("level %d: got error %v", level, err)
+		}
+	}
+}
+
+func TestCompressionRatio(t *testing.T) {
+	series := testSeries(100, "ratio")
+	b, err := newBatch(series, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decodeWriteRequest(t, b.data).Timeseries; len(got) != 100 {
+		t.Fatalf("decoded %d series, want 100", len(got))
+	}
+	uncompressed := (&prompb.WriteRequest{Timeseries: series}).Size()
+	want := float64(uncompressed) / float64(len(b.data))
+	if got := metricValue(compressionRatio); got != want {
+		t.Errorf("ratio = %v, want %v", got, want)
+	}
+
+	// Requests that aren't batches, like readiness probes, leave it alone.
+	if _, err := buildWriteRequest(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := metricValue(compressionRatio); got != want {
+		t.Errorf("ratio changed to %v by an empty request", got)
+	}
+}
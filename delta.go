@@ -0,0 +1,133 @@
+package main
+
+import (
+	"sync"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// deltaTracker turns cumulative counter values into the increase since the
+// last push that was delivered, for receivers expecting delta temporality.
+type deltaTracker struct {
+	mu sync.Mutex
+	// last holds the cumulative value of every counter series as of the
+	// last delivered push.
+	last map[string]float64
+	// pending holds the cumulative values of series collected but not yet
+	// delivered, by series and sample timestamp.
+	pending map[string]map[int64]float64
+}
+
+func newDeltaTracker() *deltaTracker {
+	return &deltaTracker{
+		last:    map[string]float64{},
+		pending: map[string]map[int64]float64{},
+	}
+}
+
+// counterNames returns the names of the counter families.
+func counterNames(mfs []*dto.MetricFamily) map[string]bool {
+	names := map[string]bool{}
+	for _, mf := range mfs {
+		if mf.GetType() == dto.MetricType_COUNTER {
+			names[mf.GetName()] = true
+		}
+	}
+	return names
+}
+
+// apply replaces the value of every counter series by its increase since the
+// last delivered push. A counter seen for the first time, or one that was
+// reset, reports its whole value. Unless record is set, nothing is
+// remembered, so the call can be repeated; otherwise the cumulative values
+// are kept until commit is called with the series once they are delivered.
+func (d *deltaTracker) apply(series []prompb.TimeSeries, counters map[string]bool, record bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	seen := make(map[string]bool, len(d.last))
+	for i := range series {
+		s := &series[i]
+		if !counters[labelValue(s.Labels, model.MetricNameLabel)] || len(s.Samples) == 0 {
+			continue
+		}
+		key := seriesKey(s.Labels)
+		seen[key] = true
+		prev, ok := d.last[key]
+		for j := range s.Samples {
+			v := s.Samples[j].Value
+			if record {
+				d.remember(key, s.Samples[j].Timestamp, v)
+			}
+			if ok && v >= prev {
+				s.Samples[j].Value = v - prev
+			}
+			prev, ok = v, true
+		}
+	}
+
+	if record {
+		// Series that disappeared are forgotten, so they start over
+		// should they come back.
+		for key := range d.last {
+			if !seen[key] {
+				delete(d.last, key)
+			}
+		}
+		for key := range d.pending {
+			if !seen[key] {
+				delete(d.pending, key)
+			}
+		}
+	}
+}
+
+// remember keeps the cumulative value v of the sample of series key at ts.
+// Only as many values are kept as batches can be waiting to be sent.
+func (d *deltaTracker) remember(key string, ts int64, v float64) {
+	values, ok := d.pending[key]
+	if !ok {
+		values = map[int64]float64{}
+		d.pending[key] = values
+	}
+	values[ts] = v
+	for len(values) > maxDeferredBatches+1 {
+		oldest := ts
+		for t := range values {
+			if t < oldest {
+				oldest = t
+			}
+		}
+		delete(values, oldest)
+	}
+}
+
+// commit makes the delivered series the base of the next deltas.
+func (d *deltaTracker) commit(series []prompb.TimeSeries) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, s := range series {
+		if len(s.Samples) == 0 {
+			continue
+		}
+		key := seriesKey(s.Labels)
+		values, ok := d.pending[key]
+		if !ok {
+			continue
+		}
+		ts := s.Samples[len(s.Samples)-1].Timestamp
+		v, ok := values[ts]
+		if !ok {
+			continue
+		}
+		d.last[key] = v
+		for t := range values {
+			if t <= ts {
+				delete(values, t)
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func deltaSeries(name string, v float64, ts int64) prompb.TimeSeries {
+	return prompb.TimeSeries{
+		Labels:  []prompb.Label{{Name: "__name__", Value: name}},
+		Samples: []prompb.Sample{{Value: v, Timestamp: ts}},
+	}
+}
+
+// push runs a delta cycle over the given cumulative values and returns the
+// values sent, committing them if delivered.
+func push(d *deltaTracker, ts int64, delivered bool, values map[string]float64) map[string]float64 {
+	var series []prompb.TimeSeries
+	for name, v := range values {
+		series = append(series, deltaSeries(name, v, ts))
+	}
+	d.apply(series, map[string]bool{"requests_total": true, "errors_total": true}, true)
+	if delivered {
+		d.commit(series)
+	}
+	sent := map[string]float64{}
+	for _, s := range series {
+		sent[labelValue(s.Labels, "__name__")] = s.Samples[0].Value
+	}
+	return sent
+}
+
+func TestDeltaTracker(t *testing.T) {
+	d := newDeltaTracker()
+	for i, c := range []struct {
+		delivered bool
+		values    map[string]float64
+		want      map[string]float64
+	}{
+		// A new counter reports its whole value, gauges are left alone.
+		{true, map[string]float64{"requests_total": 10, "temperature": 20}, map[string]float64{"requests_total": 10, "temperature": 20}},
+		// Increments.
+		{true, map[string]float64{"requests_total": 15, "temperature": 21}, map[string]float64{"requests_total": 5, "temperature": 21}},
+		// A counter appearing later.
+		{true, map[string]float64{"requests_total": 18, "errors_total": 2}, map[string]float64{"requests_total": 3, "errors_total": 2}},
+		// A reset reports the new value.
+		{true, map[string]float64{"requests_total": 4, "errors_total": 3}, map[string]float64{"requests_total": 4, "errors_total": 1}},
+		// A push that isn't delivered doesn't move the base...
+		{false, map[string]float64{"requests_total": 9, "errors_total": 3}, map[string]float64{"requests_total": 5, "errors_total": 0}},
+		// ...so its increase is part of the next one.
+		{true, map[string]float64{"requests_total": 12, "errors_total": 3}, map[string]float64{"requests_total": 8, "errors_total": 0}},
+	} {
+		got := push(d, int64(i+1)*1000, c.delivered, c.values)
+		for name, want := range c.want {
+			if got[name] != want {
+				t.Errorf("push %d: %s = %v, want %v", i, name, got[name], want)
+			}
+		}
+	}
+}
+
+func TestDeltaTrackerDryRun(t *testing.T) {
+	d := newDeltaTracker()
+	push(d, 1000, true, map[string]float64{"requests_total": 10})
+
+	for i := 0; i < 2; i++ {
+		series := []prompb.TimeSeries{deltaSeries("requests_total", 14, 2000)}
+		d.apply(series, map[string]bool{"requests_total": true}, false)
+		if v := series[0].Samples[0].Value; v != 4 {
+			t.Errorf("dry run %d: got %v, want 4", i, v)
+		}
+	}
+	if got := push(d, 2000, true, map[string]float64{"requests_total": 14}); got["requests_total"] != 4 {
+		t.Errorf("after dry runs: got %v, want 4", got["requests_total"])
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Reasons for which samples are dropped instead of being sent.
+const (
+	dropReasonOutOfOrder = "out_of_order"
+	dropReasonCeiling    = "over_ceiling"
+	dropReasonOversized  = "oversized"
+	dropReasonBudget     = "over_budget"
+	dropReasonSendFailed = "send_failed"
+	dropReasonSampledOut = "sampled_out"
+	dropReasonNameCap    = "over_metric_names"
+	dropReasonStatic     = "static"
+	dropReasonHistogram  = "malformed_histogram"
+	dropReasonReserved   = "reserved_label"
+	dropReasonEncode     = "encode_failed"
+)
+
+var droppedSamples = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "remote_write_dropped_total",
+	Help: "Samples dropped instead of being sent, by reason",
+}, []string{"reason"})
+
+func init() {
+	for _, reason := range []string{
+		dropReasonOutOfOrder,
+		dropReasonCeiling,
+		dropReasonOversized,
+		dropReasonBudget,
+		dropReasonSendFailed,
+		dropReasonSampledOut,
+		dropReasonNameCap,
+		dropReasonStatic,
+		dropReasonHistogram,
+		dropReasonReserved,
+		dropReasonEncode,
+	} {
+		droppedSamples.WithLabelValues(reason)
+	}
+}
+
+func countSamples(series []prompb.TimeSeries) int {
+	n := 0
+	for _, s := range series {
+		n += len(s.Samples)
+	}
+	return n
+}
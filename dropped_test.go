@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// droppedByReason returns the current value of every drop reason.
+func droppedByReason() map[string]float64 {
+	values := map[string]float64{}
+	for _, reason := range []string{dropReasonCeiling, dropReasonHistogram, dropReasonReserved, dropReasonSendFailed} {
+		values[reason] = metricValue(droppedSamples.WithLabelValues(reason))
+	}
+	return values
+}
+
+// malformedHistogram returns a histogram family whose bucket counts
+// decrease.
+func malformedHistogram() *dto.MetricFamily {
+	return &dto.MetricFamily{
+		Name: proto.String("test_malformed_seconds"),
+		Help: proto.String("test"),
+		Type: dto.MetricType_HISTOGRAM.Enum(),
+		Metric: []*dto.Metric{{
+			Histogram: &dto.Histogram{
+				SampleCount: proto.Uint64(5),
+				SampleSum:   proto.Float64(1),
+				Bucket: []*dto.Bucket{
+					{UpperBound: proto.Float64(0.1), CumulativeCount: proto.Uint64(4)},
+					{UpperBound: proto.Float64(1), CumulativeCount: proto.Uint64(2)},
+				},
+			},
+		}},
+	}
+}
+
+func TestDroppedSamplesByReason(t *testing.T) {
+	defer func(prev bool) { emitTargetInfo = prev }(emitTargetInfo)
+	defer func(prev int) { maxTotalSeries = prev }(maxTotalSeries)
+	defer func(prev string) { histogramPolicy = prev }(histogramPolicy)
+	emitTargetInfo = false
+	maxTotalSeries = 4
+	histogramPolicy = histogramPolicyReject
+
+	r := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_dropped", Help: "test"}, []string{"i"})
+	r.MustRegister(g)
+	for i := 0; i < 10; i++ {
+		g.WithLabelValues(fmt.Sprint(i)).Set(float64(i))
+	}
+	extra := malformedHistogram
+	gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := r.Gather()
+		return append(mfs, extra()), err
+	})
+
+	before := droppedByReason()
+	p := newPipeline(gatherer, nil)
+	batches, err := nextBatches(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendBatches(context.Background(), &flakyClient{failures: 1, err: errors.New("unavailable")}, p, nil, batches, time.Now().Add(time.Minute), make(chan struct{}))
+
+	// The reserved label policy fails the whole push.
+	defer func(prev string) { reservedLabelsPolicy = prev }(reservedLabelsPolicy)
+	reservedLabelsPolicy = reservedLabelsFail
+	g.WithLabelValues("reserved").Set(1)
+	extra = func() *dto.MetricFamily {
+		return &dto.MetricFamily{
+			Name: proto.String("test_reserved"),
+			Help: proto.String("test"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{
+				Label: []*dto.LabelPair{{Name: proto.String("__internal"), Value: proto.String("x")}},
+				Gauge: &dto.Gauge{Value: proto.Float64(1)},
+			}},
+		}
+	}
+	if _, err := nextBatches(p); err == nil {
+		t.Error("push with a reserved label didn't fail")
+	}
+
+	after := droppedByReason()
+	for reason, want := range map[string]float64{
+		// 10 gauges over a ceiling of 4.
+		dropReasonCeiling: 6,
+		// 2 buckets, the implied +Inf bucket, the sum and the count.
+		dropReasonHistogram: 5,
+		// The 4 series under the ceiling.
+		dropReasonSendFailed: 4,
+		// 11 gauges and the series with the reserved label.
+		dropReasonReserved: 12,
+	} {
+		if got := after[reason] - before[reason]; got != want {
+			t.Errorf("%s: counted %v, want %v", reason, got, want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// dumpVersion is the version of the format written by DumpTimeSeries. It must
+// be bumped whenever the format changes, so that golden files recorded with an
+// older version are rejected instead of silently misread.
+const dumpVersion byte = 1
+
+// DumpTimeSeries writes ts to w in a compact binary format that can be read
+// back with LoadTimeSeries: a version byte, followed by the length of the
+// series as a uvarint and the series as an uncompressed protobuf
+// WriteRequest.
+func DumpTimeSeries(w io.Writer, ts []prompb.TimeSeries) error {
+	data, err := (&prompb.WriteRequest{Timeseries: ts}).Marshal()
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 1+binary.MaxVarintLen64)
+	buf[0] = dumpVersion
+	n := binary.PutUvarint(buf[1:], uint64(len(data)))
+	if _, err := w.Write(buf[:1+n]); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// LoadTimeSeries reads series written by DumpTimeSeries.
+func LoadTimeSeries(r io.Reader) ([]prompb.TimeSeries, error) {
+	br := bufio.NewReader(r)
+	version, err := br.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("reading dump version: %v", err)
+	}
+	if version != dumpVersion {
+		return nil, fmt.Errorf("unsupported dump version %d, expected %d", version, dumpVersion)
+	}
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("reading dump length: %v", err)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(br, int64(size)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) != size {
+		return nil, fmt.Errorf("truncated dump: got %d of %d bytes", len(data), size)
+	}
+
+	var req prompb.WriteRequest
+	if err := req.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	return req.Timeseries, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestDumpLoadRoundTrip(t *testing.T) {
+	want := []prompb.TimeSeries{
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "a"}, {Name: "job", Value: "x"}},
+			Samples: []prompb.Sample{{Value: 1.5, Timestamp: 1000}, {Value: 2, Timestamp: 2000}},
+		},
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "b"}},
+			Samples: []prompb.Sample{{Value: -3, Timestamp: 1000}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := DumpTimeSeries(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadTimeSeries(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLoadTimeSeriesErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := DumpTimeSeries(&buf, []prompb.TimeSeries{{
+		Labels: []prompb.Label{{Name: "__name__", Value: "a"}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	wrongVersion := append([]byte{dumpVersion + 1}, data[1:]...)
+	if _, err := LoadTimeSeries(bytes.NewReader(wrongVersion)); err == nil || !strings.Contains(err.Error(), "unsupported dump version") {
+		t.Errorf("loading a dump with another version: got error %v", err)
+	}
+	if _, err := LoadTimeSeries(bytes.NewReader(data[:len(data)-1])); err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("loading a truncated dump: got error %v", err)
+	}
+}
+
+// TestConversionGolden compares the conversion of a fixed registry to
+// testdata/conversion.golden. Run with -update to record a new golden file
+// after an intended change of the conversion.
+func TestConversionGolden(t *testing.T) {
+	r := prometheus.NewRegistry()
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "golden_requests_total", Help: "test"}, []string{"code"})
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "golden_temperature", Help: "test"})
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "golden_latency_seconds", Help: "test", Buckets: []float64{0.1, 1}})
+	r.MustRegister(c, g, h)
+	c.WithLabelValues("200").Add(3)
+	c.WithLabelValues("500").Inc()
+	g.Set(-7.25)
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := metricFamilyToTimeseries(mfs, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The label order of converted series isn't defined.
+	for _, s := range got {
+		sortLabels(s.Labels)
+	}
+	sort.Slice(got, func(i, j int) bool {
+		return seriesKey(got[i].Labels) < seriesKey(got[j].Labels)
+	})
+
+	path := filepath.Join("testdata", "conversion.golden")
+	if *update {
+		var buf bytes.Buffer
+		if err := DumpTimeSeries(&buf, got); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := LoadTimeSeries(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("conversion differs from %s:\ngot  %v\nwant %v", path, got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// externalLabelsFile holds labels read from a JSON file, reloaded whenever
+// the file's modification time changes. On a failed reload the previously
+// loaded labels are kept.
+type externalLabelsFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	labels  map[string]string
+}
+
+func newExternalLabelsFile(path string) (*externalLabelsFile, error) {
+	f := &externalLabelsFile{path: path}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// current returns the labels, reloading the file first if it has changed.
+func (f *externalLabelsFile) current() map[string]string {
+	if err := f.reload(); err != nil {
+		log.Printf("keeping previous external labels, reloading %s failed: %v", f.path, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.labels
+}
+
+func (f *externalLabelsFile) reload() error {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if fi.ModTime().Equal(f.modTime) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	labels := map[string]string{}
+	if err := json.Unmarshal(data, &labels); err != nil {
+		return err
+	}
+	f.labels = labels
+	f.modTime = fi.ModTime()
+	return nil
+}
+
+// addLabels adds the given labels to every series that doesn't already have
+// a label of the same name, keeping the labels of each series sorted.
+func addLabels(series []prompb.TimeSeries, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	for i := range series {
+		present := make(map[string]struct{}, len(series[i].Labels))
+		for _, l := range series[i].Labels {
+			present[l.Name] = struct{}{}
+		}
+		for name, value := range labels {
+			if _, ok := present[name]; ok {
+				continue
+			}
+			series[i].Labels = append(series[i].Labels, prompb.Label{Name: name, Value: value})
+		}
+		sortLabels(series[i].Labels)
+	}
+}
+
+func sortLabels(labels []prompb.Label) {
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// writeLabelsFile writes data to path with the given modification time, so
+// reloads don't depend on the file system's timestamp resolution.
+func writeLabelsFile(t *testing.T, path, data string, modTime time.Time) {
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExternalLabelsFileReload(t *testing.T) {
+	defer func(prev bool) { emitTargetInfo = prev }(emitTargetInfo)
+	emitTargetInfo = false
+
+	dir, err := ioutil.TempDir("", "external-labels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "labels.json")
+	start := time.Now().Add(-time.Hour)
+	writeLabelsFile(t, path, `{"cluster": "one", "room": "external"}`, start)
+
+	f, err := newExternalLabelsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_labelled", Help: "test"}, []string{"room"})
+	r.MustRegister(g)
+	g.WithLabelValues("kitchen").Set(1)
+	p := newPipeline(r, f)
+
+	collect := func() (cluster, room string) {
+		samples, err := p.collect(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := seriesByName(samples, "test_labelled")
+		if len(s) != 1 {
+			t.Fatalf("got %d series, want 1", len(s))
+		}
+		return labelValue(s[0].Labels, "cluster"), labelValue(s[0].Labels, "room")
+	}
+
+	cluster, room := collect()
+	if cluster != "one" {
+		t.Errorf("got cluster=%q, want one", cluster)
+	}
+	if room != "kitchen" {
+		t.Errorf("got room=%q, the series' own label must win", room)
+	}
+
+	writeLabelsFile(t, path, `{"cluster": "two"}`, start.Add(time.Minute))
+	if cluster, _ := collect(); cluster != "two" {
+		t.Errorf("after reload: got cluster=%q, want two", cluster)
+	}
+
+	writeLabelsFile(t, path, `{not json`, start.Add(2*time.Minute))
+	if cluster, _ := collect(); cluster != "two" {
+		t.Errorf("after a failed reload: got cluster=%q, want the previous two", cluster)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var endpointUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "remote_write_endpoint_up",
+	Help: "Whether the remote write endpoint is considered healthy",
+}, []string{"endpoint"})
+
+// healthStatus keeps track of what the remote write loop has been doing, so it
+// can be reported through /healthz/detail.
+type healthStatus struct {
+	mu              sync.RWMutex
+	lastGather      time.Time
+	lastGatherError string
+	endpoints       map[string]*endpointHealth
+}
+
+type endpointHealth struct {
+	Up                  bool      `json:"up"`
+	LastSuccess         time.Time `json:"last_success"`
+	LastError           string    `json:"last_error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+
+	failingSince    time.Time
+	succeedingSince time.Time
+}
+
+var health = &healthStatus{
+	endpoints: map[string]*endpointHealth{},
+}
+
+func (h *healthStatus) gathered(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastGather = time.Now()
+	h.lastGatherError = errString(err)
+}
+
+func (h *healthStatus) pushed(endpoint string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.endpoints[endpoint]
+	if !ok {
+		e = &endpointHealth{Up: true}
+		h.endpoints[endpoint] = e
+	}
+	e.LastError = errString(err)
+
+	// An endpoint only goes down once failures persisted for the grace
+	// period or the failure threshold is reached, and only comes back up
+	// after succeeding for the whole recovery window.
+	now := time.Now()
+	if err != nil {
+		e.ConsecutiveFailures++
+		e.succeedingSince = time.Time{}
+		if e.failingSince.IsZero() {
+			e.failingSince = now
+		}
+		if now.Sub(e.failingSince) >= endpointFailureGrace ||
+			(endpointFailureThreshold > 0 && e.ConsecutiveFailures >= endpointFailureThreshold) {
+			e.Up = false
+		}
+	} else {
+		e.LastSuccess = now
+		e.ConsecutiveFailures = 0
+		e.failingSince = time.Time{}
+		if e.succeedingSince.IsZero() {
+			e.succeedingSince = now
+		}
+		if now.Sub(e.succeedingSince) >= endpointRecoveryWindow {
+			e.Up = true
+		}
+	}
+
+	if e.Up {
+		endpointUp.WithLabelValues(endpoint).Set(1)
+	} else {
+		endpointUp.WithLabelValues(endpoint).Set(0)
+	}
+}
+
+// detail returns the health of every subsystem keyed by subsystem name.
+func (h *healthStatus) detail() map[string]interface{} {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	endpoints := map[string]endpointHealth{}
+	for name, e := range h.endpoints {
+		endpoints[name] = *e
+	}
+	return map[string]interface{}{
+		"gather": map[string]interface{}{
+			"last_gather": h.lastGather,
+			"last_error":  h.lastGatherError,
+		},
+		"remote_write": map[string]interface{}{
+			"endpoints": endpoints,
+		},
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+func healthzDetailHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(health.detail()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthzDetail(t *testing.T) {
+	health.gathered(nil)
+	health.pushed("test-endpoint", errors.New("push failed"))
+
+	rec := httptest.NewRecorder()
+	healthzDetailHandler(rec, httptest.NewRequest("GET", "/healthz/detail", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got Content-Type %q", ct)
+	}
+
+	var detail map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &detail); err != nil {
+		t.Fatal(err)
+	}
+	for subsystem, keys := range map[string][]string{
+		"gather":       {"last_gather", "last_error"},
+		"remote_write": {"endpoints"},
+	} {
+		for _, key := range keys {
+			if _, ok := detail[subsystem][key]; !ok {
+				t.Errorf("%s has no %s: %s", subsystem, key, rec.Body)
+			}
+		}
+	}
+
+	var endpoints map[string]map[string]interface{}
+	if err := json.Unmarshal(detail["remote_write"]["endpoints"], &endpoints); err != nil {
+		t.Fatal(err)
+	}
+	e, ok := endpoints["test-endpoint"]
+	if !ok {
+		t.Fatalf("test-endpoint missing from %s", rec.Body)
+	}
+	for _, key := range []string{"up", "last_success", "last_error", "consecutive_failures"} {
+		if _, ok := e[key]; !ok {
+			t.Errorf("endpoint has no %s: %v", key, e)
+		}
+	}
+}
+
+func TestEndpointFailureGrace(t *testing.T) {
+	defer func(grace, recovery time.Duration, threshold int) {
+		endpointFailureGrace, endpointRecoveryWindow, endpointFailureThreshold = grace, recovery, threshold
+	}(endpointFailureGrace, endpointRecoveryWindow, endpointFailureThreshold)
+	endpointFailureGrace = time.Hour
+	endpointRecoveryWindow = time.Hour
+	endpointFailureThreshold = 3
+
+	h := &healthStatus{endpoints: map[string]*endpointHealth{}}
+	up := func() bool {
+		return h.endpoints["grace"].Up && metricValue(endpointUp.WithLabelValues("grace")) == 1
+	}
+
+	h.pushed("grace", errors.New("transient"))
+	h.pushed("grace", nil)
+	h.pushed("grace", errors.New("transient"))
+	if !up() {
+		t.Fatal("endpoint went down on transient failures within the grace period")
+	}
+
+	h.pushed("grace", errors.New("persistent"))
+	h.pushed("grace", errors.New("persistent"))
+	if up() {
+		t.Fatal("endpoint still up after -endpoint-failure-threshold consecutive failures")
+	}
+
+	h.pushed("grace", nil)
+	if up() {
+		t.Error("endpoint came back up before the recovery window")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Policies for histograms with malformed buckets.
+const (
+	histogramPolicyOff    = "off"
+	histogramPolicyRepair = "repair"
+	histogramPolicyReject = "reject"
+)
+
+var histogramRepairs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "remote_write_histogram_repairs_total",
+	Help: "Malformed histograms found before conversion, by problem and the action taken",
+}, []string{"problem", "action"})
+
+// checkHistograms looks for histograms whose cumulative bucket counts
+// decrease or exceed the sample count. A missing +Inf bucket is fine, it's
+// implied by the sample count. With the repair policy, counts are clamped to
+// be monotonic and the sample count is raised to the highest bucket count;
+// with the reject policy, the histogram is left out. It returns the number of
+// samples rejected histograms would have produced. A dry run doesn't count
+// the problems found.
+func checkHistograms(mfs []*dto.MetricFamily, policy string, dryRun bool) int {
+	if policy == histogramPolicyOff {
+		return 0
+	}
+	rejected := 0
+	for _, mf := range mfs {
+		if mf.GetType() != dto.MetricType_HISTOGRAM {
+			continue
+		}
+		metrics := mf.Metric[:0]
+		for _, m := range mf.Metric {
+			if m.Histogram == nil || checkHistogram(m.Histogram, policy, dryRun) {
+				metrics = append(metrics, m)
+				continue
+			}
+			rejected += histogramSamples(m.Histogram)
+		}
+		mf.Metric = metrics
+	}
+	return rejected
+}
+
+// histogramSamples returns the number of samples h is converted to: one per
+// bucket, the implied +Inf bucket, the sum and the count.
+func histogramSamples(h *dto.Histogram) int {
+	n := len(h.Bucket) + 2
+	if len(h.Bucket) == 0 || !math.IsInf(h.Bucket[len(h.Bucket)-1].GetUpperBound(), +1) {
+		n++
+	}
+	return n
+}
+
+// checkHistogram reports whether h should be kept.
+func checkHistogram(h *dto.Histogram, policy string, dryRun bool) bool {
+	action := "repaired"
+	if policy == histogramPolicyReject {
+		action = "rejected"
+	}
+	found := func(problem string) {
+		if !dryRun {
+			histogramRepairs.WithLabelValues(problem, action).Inc()
+		}
+	}
+
+	buckets := h.Bucket
+	sort.SliceStable(buckets, func(i, j int) bool {
+		return buckets[i].GetUpperBound() < buckets[j].GetUpperBound()
+	})
+
+	monotonic := true
+	var max uint64
+	for _, b := range buckets {
+		if b.GetCumulativeCount() < max {
+			monotonic = false
+			if policy == histogramPolicyRepair {
+				b.CumulativeCount = proto.Uint64(max)
+			}
+		}
+		if b.GetCumulativeCount() > max {
+			max = b.GetCumulativeCount()
+		}
+	}
+	if !monotonic {
+		found("non_monotonic")
+		if policy == histogramPolicyReject {
+			return false
+		}
+	}
+
+	if max > h.GetSampleCount() {
+		found("count_below_buckets")
+		if policy == histogramPolicyReject {
+			return false
+		}
+		h.SampleCount = proto.Uint64(max)
+		if n := len(buckets); n > 0 && math.IsInf(buckets[n-1].GetUpperBound(), +1) {
+			buckets[n-1].CumulativeCount = proto.Uint64(max)
+		}
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func histogramFamily(count uint64, buckets ...[2]float64) []*dto.MetricFamily {
+	h := &dto.Histogram{SampleCount: proto.Uint64(count), SampleSum: proto.Float64(1)}
+	for _, b := range buckets {
+		h.Bucket = append(h.Bucket, &dto.Bucket{UpperBound: proto.Float64(b[0]), CumulativeCount: proto.Uint64(uint64(b[1]))})
+	}
+	return []*dto.MetricFamily{{
+		Name:   proto.String("test_seconds"),
+		Type:   dto.MetricType_HISTOGRAM.Enum(),
+		Metric: []*dto.Metric{{Histogram: h}},
+	}}
+}
+
+func repairs(problem, action string) float64 {
+	return metricValue(histogramRepairs.WithLabelValues(problem, action))
+}
+
+func TestCheckHistogramsWellFormed(t *testing.T) {
+	inf := math.Inf(1)
+	for name, mfs := range map[string][]*dto.MetricFamily{
+		"without +Inf": histogramFamily(5, [2]float64{0.1, 2}, [2]float64{1, 4}),
+		"with +Inf":    histogramFamily(5, [2]float64{0.1, 2}, [2]float64{1, 4}, [2]float64{inf, 5}),
+		"no buckets":   histogramFamily(5),
+	} {
+		before := repairs("count_below_buckets", "rejected") + repairs("non_monotonic", "rejected")
+		if n := checkHistograms(mfs, histogramPolicyReject, false); n != 0 {
+			t.Errorf("%s: rejected %d samples", name, n)
+		}
+		if len(mfs[0].Metric) != 1 {
+			t.Errorf("%s: histogram was removed", name)
+		}
+		if got := repairs("count_below_buckets", "rejected") + repairs("non_monotonic", "rejected") - before; got != 0 {
+			t.Errorf("%s: counted %v problems", name, got)
+		}
+	}
+}
+
+func TestCheckHistogramsRepair(t *testing.T) {
+	mfs := histogramFamily(3, [2]float64{0.1, 4}, [2]float64{1, 2}, [2]float64{math.Inf(1), 3})
+	nonMonotonic := repairs("non_monotonic", "repaired")
+	countBelow := repairs("count_below_buckets", "repaired")
+
+	if n := checkHistograms(mfs, histogramPolicyRepair, false); n != 0 {
+		t.Errorf("repair rejected %d samples", n)
+	}
+	h := mfs[0].Metric[0].Histogram
+	for i, want := range []uint64{4, 4, 4} {
+		if got := h.Bucket[i].GetCumulativeCount(); got != want {
+			t.Errorf("bucket %d = %d, want %d", i, got, want)
+		}
+	}
+	if got := h.GetSampleCount(); got != 4 {
+		t.Errorf("sample count = %d, want 4", got)
+	}
+	if got := repairs("non_monotonic", "repaired") - nonMonotonic; got != 1 {
+		t.Errorf("counted %v non-monotonic repairs, want 1", got)
+	}
+	if got := repairs("count_below_buckets", "repaired") - countBelow; got != 1 {
+		t.Errorf("counted %v count repairs, want 1", got)
+	}
+}
+
+func TestCheckHistogramsReject(t *testing.T) {
+	for name, c := range map[string]struct {
+		mfs     []*dto.MetricFamily
+		problem string
+		samples int
+	}{
+		// 2 buckets, the implied +Inf bucket, the sum and the count.
+		"non-monotonic":       {histogramFamily(5, [2]float64{0.1, 4}, [2]float64{1, 2}), "non_monotonic", 5},
+		"count below buckets": {histogramFamily(3, [2]float64{0.1, 2}, [2]float64{1, 4}, [2]float64{math.Inf(1), 4}), "count_below_buckets", 5},
+	} {
+		before := repairs(c.problem, "rejected")
+		if n := checkHistograms(c.mfs, histogramPolicyReject, false); n != c.samples {
+			t.Errorf("%s: rejected %d samples, want %d", name, n, c.samples)
+		}
+		if len(c.mfs[0].Metric) != 0 {
+			t.Errorf("%s: histogram was kept", name)
+		}
+		if got := repairs(c.problem, "rejected") - before; got != 1 {
+			t.Errorf("%s: counted %v, want 1", name, got)
+		}
+	}
+}
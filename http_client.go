@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	config_util "github.com/prometheus/common/config"
+)
+
+const maxErrMsgLen = 256
+
+// httpWriteClient sends write requests to a remote write endpoint the same
+// way remote.Client does, but also sets the headers attached to the context
+// with withRequestHeaders.
+type httpWriteClient struct {
+	url     string
+	client  *http.Client
+	timeout time.Duration
+
+	// tenantURL is the URL template requests attached to a tenant with
+	// withTenant are sent to, if any.
+	tenantURL string
+}
+
+func newHTTPWriteClient(url string, cfg config_util.HTTPClientConfig, timeout time.Duration) (*httpWriteClient, error) {
+	client, err := config_util.NewClientFromConfig(cfg, "remote_write")
+	if err != nil {
+		return nil, err
+	}
+	client.CheckRedirect = checkRedirect
+	return &httpWriteClient{
+		url:     url,
+		client:  client,
+		timeout: timeout,
+	}, nil
+}
+
+// Store sends the snappy compressed, marshalled write request.
+func (c *httpWriteClient) Store(ctx context.Context, req []byte) error {
+	target := c.url
+	if tenant := requestTenant(ctx); tenant != "" && c.tenantURL != "" {
+		var err error
+		if target, err = expandTenantURL(c.tenantURL, tenant); err != nil {
+			return err
+		}
+	}
+	httpReq, err := http.NewRequest("POST", target, bytes.NewReader(req))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Add("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("User-Agent", "prom-remote-write-demo/"+binaryVersion)
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for name, value := range requestHeaders(ctx) {
+		httpReq.Header.Set(name, value)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	httpResp, err := c.client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, httpResp.Body)
+		httpResp.Body.Close()
+	}()
+
+	if httpResp.StatusCode/100 == 3 {
+		return fmt.Errorf("server returned HTTP status %s redirecting to %q, which isn't followed without -follow-redirects", httpResp.Status, httpResp.Header.Get("Location"))
+	}
+	if httpResp.StatusCode/100 != 2 {
+		scanner := bufio.NewScanner(io.LimitReader(httpResp.Body, maxErrMsgLen))
+		line := ""
+		if scanner.Scan() {
+			line = scanner.Text()
+		}
+		return statusError{code: httpResp.StatusCode, msg: fmt.Sprintf("server returned HTTP status %s: %s", httpResp.Status, line)}
+	}
+	return nil
+}
+
+// statusError is a push the receiver answered with a non-2xx status.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e statusError) Error() string {
+	return e.msg
+}
+
+// isTooManyRequests reports whether err is the receiver rate limiting pushes.
+func isTooManyRequests(err error) bool {
+	var se statusError
+	return errors.As(err, &se) && se.code == http.StatusTooManyRequests
+}
+
+// checkRedirect follows up to -max-redirects redirects with -follow-redirects,
+// resending the original request as it was. Without it, the redirect is
+// returned as the response. By default, net/http would turn the POST into a
+// GET without a body on 301, 302 and 303 redirects.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if !followRedirects {
+		return http.ErrUseLastResponse
+	}
+	if len(via) > maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+
+	orig := via[0]
+	body, err := orig.GetBody()
+	if err != nil {
+		return err
+	}
+	req.Method = orig.Method
+	req.Body = body
+	req.GetBody = orig.GetBody
+	req.ContentLength = orig.ContentLength
+	req.Header = orig.Header.Clone()
+	return nil
+}
+
+// Name identifies the endpoint.
+func (c *httpWriteClient) Name() string {
+	return c.url
+}
+
+type requestHeadersKey struct{}
+
+// withRequestHeaders attaches headers to be set on the write request sent
+// with ctx.
+func withRequestHeaders(ctx context.Context, headers map[string]string) context.Context {
+	return context.WithValue(ctx, requestHeadersKey{}, headers)
+}
+
+func requestHeaders(ctx context.Context) map[string]string {
+	headers, _ := ctx.Value(requestHeadersKey{}).(map[string]string)
+	return headers
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	config_util "github.com/prometheus/common/config"
+)
+
+// redirectingServer redirects /old to /new with code and records the last
+// request /new got.
+type redirectingServer struct {
+	*httptest.Server
+
+	method string
+	body   string
+	header http.Header
+}
+
+func newRedirectingServer(code int) *redirectingServer {
+	s := &redirectingServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new", code)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.method, s.body, s.header = r.Method, string(body), r.Header
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func TestFollowRedirects(t *testing.T) {
+	defer func(prev bool) { followRedirects = prev }(followRedirects)
+	followRedirects = true
+
+	cfg := config_util.HTTPClientConfig{
+		BasicAuth: &config_util.BasicAuth{Username: "user", Password: "secret"},
+	}
+	for _, code := range []int{http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect} {
+		s := newRedirectingServer(code)
+		cl, err := newHTTPWriteClient(s.URL+"/old", cfg, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := withRequestHeaders(context.Background(), map[string]string{"X-Scope-OrgID": "team-a"})
+		if err := cl.Store(ctx, []byte("payload")); err != nil {
+			t.Errorf("%d: %v", code, err)
+		}
+		if s.method != "POST" || s.body != "payload" {
+			t.Errorf("%d: redirect target got %s with body %q, want the POST with its body", code, s.method, s.body)
+		}
+		if user, pass, ok := (&http.Request{Header: s.header}).BasicAuth(); !ok || user != "user" || pass != "secret" {
+			t.Errorf("%d: redirect target got no basic auth", code)
+		}
+		for name, want := range map[string]string{
+			"X-Scope-OrgID":    "team-a",
+			"Content-Encoding": "snappy",
+			"Content-Type":     "application/x-protobuf",
+		} {
+			if got := s.header.Get(name); got != want {
+				t.Errorf("%d: redirect target got header %s: %q, want %q", code, name, got, want)
+			}
+		}
+		s.Close()
+	}
+}
+
+func TestMaxRedirects(t *testing.T) {
+	defer func(prev bool, max int) { followRedirects, maxRedirects = prev, max }(followRedirects, maxRedirects)
+	followRedirects, maxRedirects = true, 2
+
+	loop := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer loop.Close()
+	cl, err := newHTTPWriteClient(loop.URL, config_util.HTTPClientConfig{}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Store(context.Background(), []byte("payload")); err == nil || !strings.Contains(err.Error(), "stopped after 2 redirects") {
+		t.Errorf("got error %v, want one about too many redirects", err)
+	}
+}
+
+func TestRedirectNotFollowed(t *testing.T) {
+	defer func(prev bool) { followRedirects = prev }(followRedirects)
+	followRedirects = false
+
+	s := newRedirectingServer(http.StatusTemporaryRedirect)
+	defer s.Close()
+	cl, err := newHTTPWriteClient(s.URL+"/old", config_util.HTTPClientConfig{}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cl.Store(context.Background(), []byte("payload"))
+	if err == nil || !strings.Contains(err.Error(), "-follow-redirects") {
+		t.Errorf("got error %v, want one pointing at -follow-redirects", err)
+	}
+	if s.method != "" {
+		t.Errorf("redirect target got a %s without -follow-redirects", s.method)
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+// interner deduplicates strings so that equal label names and values share
+// their backing memory. A nil interner returns strings unchanged.
+type interner map[string]string
+
+func (in interner) intern(s string) string {
+	if in == nil {
+		return s
+	}
+	if v, ok := in[s]; ok {
+		return v
+	}
+	in[s] = s
+	return s
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// sharedLabelsRegistry returns a registry of n series sharing most of their
+// label values, like series of one cluster and namespace do.
+func sharedLabelsRegistry(n int) *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "test_shared",
+		Help: "test",
+	}, []string{"cluster", "namespace", "pod"})
+	r.MustRegister(g)
+	for i := 0; i < n; i++ {
+		g.WithLabelValues("production-eu-west-1", fmt.Sprintf("namespace-%d", i%10), fmt.Sprintf("pod-%d", i)).Set(float64(i))
+	}
+	return r
+}
+
+func TestInternLabelsIdenticalOutput(t *testing.T) {
+	defer func(prev bool) { internLabels = prev }(internLabels)
+	r := sharedLabelsRegistry(100)
+
+	internLabels = false
+	want := gatherSeries(t, r)
+	internLabels = true
+	got := gatherSeries(t, r)
+
+	for _, s := range append(want, got...) {
+		sortLabels(s.Labels)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Error("interning changed the converted series")
+	}
+}
+
+// parsedFamilies returns the metric families of sharedLabelsRegistry as
+// parsed from the text format, where every label value is its own string as
+// with metrics read from elsewhere.
+func parsedFamilies(b *testing.B, n int) []*dto.MetricFamily {
+	var buf bytes.Buffer
+	mfs, err := sharedLabelsRegistry(n).Gather()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if _, err := expfmt.MetricFamilyToText(&buf, mf); err != nil {
+			b.Fatal(err)
+		}
+	}
+	var parser expfmt.TextParser
+	parsed, err := parser.TextToMetricFamilies(&buf)
+	if err != nil {
+		b.Fatal(err)
+	}
+	out := make([]*dto.MetricFamily, 0, len(parsed))
+	for _, mf := range parsed {
+		out = append(out, mf)
+	}
+	return out
+}
+
+// BenchmarkInternLabels reports the allocations of the conversion and the
+// heap the converted series keep alive once the gathered metrics are gone.
+// Interning doesn't reduce allocations: the label strings are allocated by
+// the parser either way, and the interning map comes on top. Only the
+// retained heap shrinks, as the series share the strings instead of keeping
+// every copy alive.
+func BenchmarkInternLabels(b *testing.B) {
+	defer func(prev bool) { internLabels = prev }(internLabels)
+	for _, intern := range []bool{false, true} {
+		b.Run(fmt.Sprintf("intern=%v", intern), func(b *testing.B) {
+			internLabels = intern
+			var retained int64
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				mfs := parsedFamilies(b, 10000)
+				b.StartTimer()
+
+				series, err := metricFamilyToTimeseries(mfs, 1000)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				// Only the series are still referenced.
+				b.StopTimer()
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				retained += int64(after.HeapAlloc) - int64(before.HeapAlloc)
+				runtime.KeepAlive(series)
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(retained)/float64(b.N), "retained-B/op")
+		})
+	}
+}
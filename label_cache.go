@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// labelCache keeps the sorted labels of the metrics converted in the last
+// gather, keyed by fingerprint, so stable series aren't converted again on
+// every push. Entries not used in a gather are evicted at its end.
+type labelCache struct {
+	mu      sync.Mutex
+	gather  uint64
+	entries map[model.Fingerprint]*cachedLabels
+}
+
+type cachedLabels struct {
+	labels []prompb.Label
+	gather uint64
+}
+
+var labelsCache = &labelCache{
+	entries: map[model.Fingerprint]*cachedLabels{},
+}
+
+// begin starts a gather.
+func (c *labelCache) begin() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gather++
+}
+
+// end evicts the metrics that weren't seen since begin.
+func (c *labelCache) end() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for fp, e := range c.entries {
+		if e.gather != c.gather {
+			delete(c.entries, fp)
+		}
+	}
+}
+
+// labels returns the sorted labels of m. The cached labels are only used if
+// they match m exactly, so colliding fingerprints can't mix up series. The
+// returned slice is a copy, as later stages modify labels in place.
+func (c *labelCache) labels(m model.Metric, in interner) []prompb.Label {
+	fp := m.Fingerprint()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[fp]
+	if !ok || !labelsEqual(e.labels, m) {
+		labels := metricToLabels(m, in)
+		sortLabels(labels)
+		e = &cachedLabels{labels: labels}
+		c.entries[fp] = e
+	}
+	e.gather = c.gather
+	return append([]prompb.Label(nil), e.labels...)
+}
+
+func labelsEqual(labels []prompb.Label, m model.Metric) bool {
+	if len(labels) != len(m) {
+		return false
+	}
+	for _, l := range labels {
+		if v, ok := m[model.LabelName(l.Name)]; !ok || string(v) != l.Value {
+			return false
+		}
+	}
+	return true
+}
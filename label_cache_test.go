@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func newLabelCache() *labelCache {
+	return &labelCache{entries: map[model.Fingerprint]*cachedLabels{}}
+}
+
+func TestLabelCacheFingerprintCollision(t *testing.T) {
+	c := newLabelCache()
+	m := model.Metric{"__name__": "up", "job": "api"}
+	other := []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "db"}}
+
+	// Forge an entry of another metric under the fingerprint of m.
+	c.begin()
+	c.entries[m.Fingerprint()] = &cachedLabels{labels: other}
+	got := c.labels(m, nil)
+	c.end()
+
+	want := []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "api"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got labels %v for a colliding fingerprint, want %v", got, want)
+	}
+	if e := c.entries[m.Fingerprint()]; !reflect.DeepEqual(e.labels, want) {
+		t.Errorf("cache holds %v after the collision, want %v", e.labels, want)
+	}
+}
+
+func TestLabelCacheEviction(t *testing.T) {
+	c := newLabelCache()
+	a := model.Metric{"__name__": "a"}
+	b := model.Metric{"__name__": "b"}
+
+	c.begin()
+	c.labels(a, nil)
+	c.labels(b, nil)
+	c.end()
+	c.begin()
+	c.labels(a, nil)
+	c.end()
+
+	if _, ok := c.entries[a.Fingerprint()]; !ok {
+		t.Error("evicted a series seen in the last gather")
+	}
+	if _, ok := c.entries[b.Fingerprint()]; ok {
+		t.Error("kept a series that disappeared")
+	}
+}
+
+func TestLabelCacheReturnsCopy(t *testing.T) {
+	c := newLabelCache()
+	m := model.Metric{"__name__": "up", "job": "api"}
+
+	c.begin()
+	c.labels(m, nil)[1].Value = "changed"
+	if got := c.labels(m, nil)[1].Value; got != "api" {
+		t.Errorf("cached label changed to %q by the caller", got)
+	}
+	c.end()
+}
+
+func BenchmarkLabelConversion(b *testing.B) {
+	metrics := make([]model.Metric, 1000)
+	for i := range metrics {
+		m := model.Metric{"__name__": "http_requests_total"}
+		for j := 0; j < 8; j++ {
+			m[model.LabelName(fmt.Sprintf("label_%d", j))] = model.LabelValue(fmt.Sprintf("value_%d_%d", j, i))
+		}
+		metrics[i] = m
+	}
+
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, m := range metrics {
+				sortLabels(metricToLabels(m, nil))
+			}
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		c := newLabelCache()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c.begin()
+			for _, m := range metrics {
+				c.labels(m, nil)
+			}
+			c.end()
+		}
+	})
+}
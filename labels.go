@@ -0,0 +1,27 @@
+package main
+
+import (
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// seriesKey returns a string uniquely identifying the label set, independent
+// of the order of the labels.
+func seriesKey(labels []prompb.Label) string {
+	pairs := make([]string, 0, len(labels))
+	for _, l := range labels {
+		pairs = append(pairs, l.Name+"\xff"+l.Value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "\xfe")
+}
+
+// seriesHash returns a stable hash of the label set.
+func seriesHash(labels []prompb.Label) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(seriesKey(labels)))
+	return h.Sum64()
+}
@@ -5,13 +5,16 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gogo/protobuf/proto"
-	"github.com/golang/snappy"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	dto "github.com/prometheus/client_model/go"
@@ -22,12 +25,14 @@ import (
 	"github.com/prometheus/prometheus/storage/remote"
 )
 
+const binaryVersion = "v0.1.0"
+
 var (
 	version = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "version",
 		Help: "Version information about this binary",
 		ConstLabels: map[string]string{
-			"version": "v0.1.0",
+			"version": binaryVersion,
 		},
 	})
 
@@ -44,6 +49,30 @@ var (
 		Help: "Count of all HTTP requests",
 	}, []string{"code", "method"})
 
+	labelsPerSeries = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "remote_write_labels_per_series",
+		Help:    "Number of labels of each series sent",
+		Buckets: []float64{1, 2, 4, 8, 16, 32, 64},
+	})
+
+	// startTime is captured when the process starts, before anything is
+	// gathered.
+	startTime = time.Now()
+
+	processStartTime = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "process_start_time_seconds",
+		Help: "Start time of the process since unix epoch in seconds",
+	}, func() float64 {
+		return float64(startTime.UnixNano()) / 1e9
+	})
+
+	processUptime = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "process_uptime_seconds",
+		Help: "Seconds since the process started",
+	}, func() float64 {
+		return time.Since(startTime).Seconds()
+	})
+
 	testSummary = prometheus.NewSummary(prometheus.SummaryOpts{
 		Name: "hello_world",
 		ConstLabels: map[string]string{
@@ -52,17 +81,312 @@ var (
 	})
 )
 
+var (
+	// roundValuesDecimals rounds every sample value to that many decimal
+	// places. Negative disables rounding.
+	roundValuesDecimals = -1
+
+	// strictOrdering guarantees that samples of a series are sent in
+	// non-decreasing timestamp order across pushes.
+	strictOrdering = false
+
+	// timestampLabel names a label holding the sample timestamp in unix
+	// milliseconds. Empty disables it.
+	timestampLabel = ""
+
+	// mergeSameSeries coalesces series with identical labels before
+	// building the write request.
+	mergeSameSeries = false
+
+	// syntheticMetrics adds series describing the conversion itself, like
+	// Prometheus does for scrapes.
+	syntheticMetrics = false
+
+	// maxTotalSeries caps the number of series sent per push. Zero means
+	// no limit.
+	maxTotalSeries = 0
+
+	// abortIfRequestExceeds drops series that on their own would produce a
+	// request body larger than that many bytes. Zero means no limit.
+	abortIfRequestExceeds = 0
+
+	// pushRetries is how many times a failed push is retried before the
+	// batch is dropped, waiting pushRetryBackoff (doubling) in between.
+	pushRetries      = 0
+	pushRetryBackoff = time.Second
+
+	// retryOnlyOnConnectionErrors limits retries to pushes that never
+	// reached the receiver, so that none can be applied twice.
+	retryOnlyOnConnectionErrors = false
+
+	// pushInterval is the time between two push cycles. Retries of a
+	// cycle stop a tenth of it before the next cycle is due.
+	pushInterval = 5 * time.Second
+
+	// pushTimeout bounds a single push attempt. Attempts are cut short
+	// earlier if the push cycle ends first.
+	pushTimeout = 50 * time.Second
+
+	// adaptiveInterval lets the push interval grow up to maxPushInterval
+	// while the receiver is rate limiting or slow.
+	adaptiveInterval = false
+	maxPushInterval  = time.Minute
+
+	// poolWriteRequests reuses write requests and marshalling buffers
+	// between pushes.
+	poolWriteRequests = false
+
+	// instanceLabel names the label identifying this pusher, set to
+	// instanceID. Empty disables it.
+	instanceLabel = "remote_write_instance"
+	instanceID    = ""
+
+	// wireMaxLabelValueBytes truncates label values longer than that, right
+	// before marshalling. Zero means no limit.
+	wireMaxLabelValueBytes = 0
+	wireTruncationMarker   = "..."
+
+	// emitTargetInfo sends a target_info series describing the host and
+	// build with every push.
+	emitTargetInfo = true
+
+	// An endpoint is only considered down once pushes kept failing for
+	// endpointFailureGrace or endpointFailureThreshold consecutive times,
+	// and up again after succeeding for endpointRecoveryWindow.
+	endpointFailureGrace     time.Duration
+	endpointFailureThreshold = 0
+	endpointRecoveryWindow   time.Duration
+
+	// internLabels makes identical label names and values of a push share
+	// memory. This costs a map per push, so it allocates slightly more, but
+	// the converted series keep less memory alive.
+	internLabels = false
+
+	// startupDelay postpones the first push. With startupDelayJitter, the
+	// delay is picked at random up to startupDelay.
+	startupDelay       time.Duration
+	startupDelayJitter = false
+
+	// maxBytesPerInterval limits the compressed bytes sent per push
+	// interval, deferring batches that don't fit. Zero means no limit.
+	maxBytesPerInterval = 0
+
+	// timestampOffset shifts the timestamp of every gathered sample. Only
+	// meant for testing how receivers handle old or future samples.
+	timestampOffset time.Duration
+
+	// counterTemporality is either "cumulative" or "delta", in which case
+	// counters are sent as their increase since the previous push.
+	counterTemporality = "cumulative"
+
+	// labelCardinalityMin is the number of distinct values a label needs
+	// within labelCardinalityWindow to be reported. Zero disables tracking.
+	labelCardinalityMin    = 0
+	labelCardinalityWindow = 10 * time.Minute
+
+	// internalMetricsPrefix prefixes the names of the pusher's own
+	// metrics.
+	internalMetricsPrefix = "rwdemo"
+
+	// pushInternalMetrics sends the pusher's own metrics along with the
+	// forwarded ones. They're always served on /internal-metrics.
+	pushInternalMetrics = false
+
+	// emitExternalLabelsInfo sends a series carrying the external labels
+	// with every push.
+	emitExternalLabelsInfo = false
+
+	// sampleFraction is the share of series sent, chosen by label hash.
+	sampleFraction = 1.0
+
+	// histogramPolicy decides what happens to histograms with
+	// non-monotonic buckets or bucket counts above the sample count.
+	histogramPolicy = histogramPolicyRepair
+
+	// compressionLevel is the level of the request codec, for codecs that
+	// have levels.
+	compressionLevel = 0
+
+	// While paused, pushes are skipped. With pausedGather the metrics are
+	// still gathered and converted, then discarded.
+	pausedGather   = false
+	pausedNotReady = false
+
+	// sequenceHeader and checksumHeader name the headers carrying the
+	// sequence number and checksum of each batch. Empty disables them.
+	sequenceHeader = ""
+	checksumHeader = ""
+
+	// maxMetricNames caps the distinct metric names sent per push. Zero
+	// means no limit.
+	maxMetricNames = 0
+
+	// pushLatencyMetrics selects how push durations are exported: as a
+	// histogram, a summary, or both.
+	pushLatencyMetrics = "histogram"
+
+	// dropStaticAfter stops sending series whose value didn't change for
+	// that long, until it changes. Zero disables it.
+	dropStaticAfter       time.Duration
+	dropStaticStaleMarker = false
+
+	// tenantLabel names the label the tenant of a series is taken from,
+	// splitting each push into one request per tenant. Series without it
+	// go to defaultTenant. The tenant is sent in tenantHeader and replaces
+	// the {tenant} placeholder of the URL.
+	tenantLabel   = ""
+	defaultTenant = "default"
+	tenantHeader  = "X-Scope-OrgID"
+
+	// reservedLabelsPolicy decides what happens to labels whose names start
+	// with __, other than __name__ and -tenant-label.
+	reservedLabelsPolicy = reservedLabelsOff
+
+	// followRedirects makes pushes follow up to maxRedirects redirects,
+	// resending the same request to the new location.
+	followRedirects = false
+	maxRedirects    = 10
+
+	// cacheLabels reuses the converted labels of series seen in the
+	// previous gather.
+	cacheLabels = false
+)
+
 func main() {
 	bind := ""
+	remoteWriteURL := ""
+	tenantID := ""
+	socks5Proxy := ""
+	externalLabelsPath := ""
+	sink := "http"
+	memorySinkCapacity := 0
+	teeFile := ""
+	readinessProbeInterval := time.Minute
+	remoteReadURL := ""
+	verifyWrites := time.Duration(0)
+	verifyWritesSeries := 0
+	startPaused := false
+	registerDemoMetrics := true
+	enableAdmin := false
 	flagset := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 	flagset.StringVar(&bind, "bind", ":8080", "The socket to bind to.")
+	flagset.StringVar(&remoteWriteURL, "remote-write-url", "http://192.168.99.100:30080/api/prom/push", "The remote write endpoint. A {tenant} placeholder is replaced with -tenant-id.")
+	flagset.StringVar(&tenantID, "tenant-id", "", "The tenant to write to, substituted into the {tenant} placeholder of -remote-write-url.")
+	flagset.IntVar(&roundValuesDecimals, "round-values-decimals", -1, "Round sample values to this many decimal places before sending. This loses precision; negative disables rounding.")
+	flagset.BoolVar(&strictOrdering, "strict-ordering", false, "Never send a sample older than one already sent for the same series, for receivers without out-of-order support.")
+	flagset.StringVar(&timestampLabel, "timestamp-from-label", "", "Use the value of this label, in unix milliseconds, as the sample timestamp and remove it from the series.")
+	flagset.BoolVar(&mergeSameSeries, "merge-series", false, "Merge series with identical labels into a single series before sending.")
+	flagset.BoolVar(&syntheticMetrics, "synthetic-metrics", false, "Send synthetic scrape_samples_scraped and scrape_samples_post_metric_relabeling series.")
+	flagset.IntVar(&maxTotalSeries, "max-total-series", 0, "Maximum number of series sent per push; a stable subset is kept and the rest dropped. 0 means no limit.")
+	flagset.IntVar(&abortIfRequestExceeds, "abort-if-request-exceeds", 0, "Drop any series that alone would produce a request body larger than this many bytes. 0 means no limit.")
+	flagset.DurationVar(&pushInterval, "push-interval", 5*time.Second, "Time between two pushes.")
+	flagset.BoolVar(&adaptiveInterval, "adaptive-interval", false, "Push less often, up to -max-push-interval, while the receiver keeps answering 429 or pushes keep taking over half the interval for 3 cycles in a row, and go back to -push-interval once it recovers.")
+	flagset.DurationVar(&maxPushInterval, "max-push-interval", time.Minute, "Longest push interval -adaptive-interval backs off to.")
+	flagset.IntVar(&pushRetries, "push-retries", 0, "How many times a failed push is retried before the batch is dropped.")
+	flagset.DurationVar(&pushRetryBackoff, "push-retry-backoff", time.Second, "Wait before the first retry of a failed push, doubled on every further retry.")
+	flagset.BoolVar(&retryOnlyOnConnectionErrors, "retry-only-on-connection-errors", false, "Only retry pushes that failed to connect or complete the TLS handshake, e.g. on certificate verification errors, never ones the receiver may have seen, such as 5xx responses and timeouts.")
+	flagset.StringVar(&socks5Proxy, "remote-write-socks5", "", "Send remote writes through this SOCKS5 proxy, given as [user:pass@]host:port.")
+	flagset.StringVar(&externalLabelsPath, "external-labels-file", "", "JSON file mapping label names to values added to every series that lacks them. Reloaded on change.")
+	flagset.BoolVar(&poolWriteRequests, "pool-write-requests", false, "Reuse write requests and marshalling buffers between pushes to reduce allocations.")
+	flagset.StringVar(&instanceLabel, "instance-label", "remote_write_instance", "Label added to every series identifying this pusher. Empty disables it.")
+	flagset.StringVar(&instanceID, "instance-id", "", "Value of -instance-label. Defaults to the hostname.")
+	flagset.StringVar(&internalMetricsPrefix, "internal-metrics-prefix", "rwdemo", "Prefix of the names of the pusher's own remote_write_* metrics. Empty disables it.")
+	flagset.BoolVar(&pushInternalMetrics, "push-internal-metrics", false, "Also remote write the pusher's own metrics. They're always served on /internal-metrics, and kept out of the pushed payload by default.")
+	flagset.BoolVar(&registerDemoMetrics, "register-demo-metrics", true, "Register the demo alert and hello_world metrics and serve the /alert/* handlers.")
+	flagset.IntVar(&wireMaxLabelValueBytes, "wire-max-label-value-bytes", 0, "Truncate label values longer than this many bytes right before sending. 0 means no limit.")
+	flagset.StringVar(&wireTruncationMarker, "wire-truncation-marker", "...", "Suffix marking label values truncated by -wire-max-label-value-bytes.")
+	flagset.BoolVar(&emitTargetInfo, "emit-target-info", true, "Send a target_info series with host, OS, architecture and build labels on every push.")
+	flagset.DurationVar(&endpointFailureGrace, "endpoint-failure-grace", 0, "How long pushes have to keep failing before the endpoint is considered down.")
+	flagset.IntVar(&endpointFailureThreshold, "endpoint-failure-threshold", 0, "Consider the endpoint down after this many consecutive failed pushes, even within -endpoint-failure-grace. 0 disables it.")
+	flagset.DurationVar(&endpointRecoveryWindow, "endpoint-recovery-window", 0, "How long pushes have to keep succeeding before a down endpoint is considered up again.")
+	flagset.BoolVar(&internLabels, "intern-labels", false, "Deduplicate identical label strings while converting, so the converted series of large registries keep less memory alive. It allocates slightly more and is slower.")
+	flagset.DurationVar(&startupDelay, "startup-delay", 0, "Wait this long before starting to push.")
+	flagset.BoolVar(&startupDelayJitter, "startup-delay-jitter", false, "Wait a random duration up to -startup-delay instead, to spread the first pushes of replicas starting together.")
+	flagset.IntVar(&maxBytesPerInterval, "max-bytes-per-interval", 0, "Maximum compressed bytes sent per push interval; batches over budget are deferred to the next interval. 0 means no limit.")
+	flagset.StringVar(&sink, "sink", "http", "Where write requests go: http sends them to -remote-write-url, memory keeps them in process, readable on /admin/memory-sink with -enable-admin.")
+	flagset.IntVar(&memorySinkCapacity, "memory-sink-capacity", 100, "How many write requests -sink=memory keeps, the oldest being dropped first. Must be at least 1.")
+	flagset.StringVar(&teeFile, "tee-file", "", "Also append every write request sent successfully to this file, once however many attempts it took, as a uvarint length followed by the compressed request. File errors never affect the push.")
+	flagset.DurationVar(&pushTimeout, "push-timeout", 50*time.Second, "Timeout of a single push attempt to the remote write endpoint. Attempts are cut short a tenth of -push-interval before the next push is due.")
+	flagset.DurationVar(&readinessProbeInterval, "readiness-probe-interval", time.Minute, "How often /readyz probes the remote write endpoint with an empty write request.")
+	flagset.StringVar(&remoteReadURL, "remote-read-url", "", "The remote read endpoint of the receiver, used by -verify-writes.")
+	flagset.DurationVar(&verifyWrites, "verify-writes", 0, "How often to read back a few just-written series through -remote-read-url and compare them to what was sent. 0 disables it.")
+	flagset.IntVar(&verifyWritesSeries, "verify-writes-series", 3, "How many series -verify-writes reads back each time.")
+	flagset.DurationVar(&timestampOffset, "timestamp-offset", 0, "DEBUG ONLY: shift the timestamps of gathered samples by this duration, which may be negative, to test receiver out-of-order and look-back handling.")
+	flagset.StringVar(&counterTemporality, "counter-temporality", "cumulative", "Send counters as cumulative values or as the delta since the previous push. One of cumulative, delta.")
+	flagset.IntVar(&labelCardinalityMin, "label-cardinality-min", 0, "Report label names with at least this many distinct values per window in remote_write_label_value_cardinality. 0 disables tracking.")
+	flagset.DurationVar(&labelCardinalityWindow, "label-cardinality-window", 10*time.Minute, "Sliding window over which distinct label values are counted.")
+	flagset.BoolVar(&emitExternalLabelsInfo, "emit-external-labels-info", false, "Send an <internal-metrics-prefix>_external_labels_info series carrying the labels of -external-labels-file.")
+	flagset.Float64Var(&sampleFraction, "sample-fraction", 1, "Fraction of series to send, between 0 and 1. The same series are kept on every push.")
+	flagset.StringVar(&histogramPolicy, "histogram-policy", histogramPolicyRepair, "What to do with histograms whose bucket counts decrease or exceed the sample count: repair them, reject them, or off to send them as they are.")
+	flagset.IntVar(&compressionLevel, "compression-level", 0, "Compression level of the request codec. snappy has no levels and only accepts 0.")
+	flagset.BoolVar(&startPaused, "start-paused", false, "Start with pushing paused until POST /admin/resume.")
+	flagset.BoolVar(&pausedGather, "paused-gather", false, "Keep gathering and converting while paused, discarding the result.")
+	flagset.BoolVar(&pausedNotReady, "paused-not-ready", false, "Report not ready on /readyz while paused.")
+	flagset.StringVar(&sequenceHeader, "sequence-header", "", "Send each batch's sequence number in this header, as <process start unix time>-<number>, for gap detection. Retries reuse the number.")
+	flagset.StringVar(&checksumHeader, "checksum-header", "", "Send each batch's SHA-256 checksum in this header.")
+	flagset.IntVar(&maxMetricNames, "max-metric-names", 0, "Maximum number of distinct metric names sent per push; a stable subset is kept and the rest dropped. 0 means no limit.")
+	flagset.StringVar(&pushLatencyMetrics, "push-latency-metrics", "histogram", "How push durations are exported: histogram, summary (p50/p90/p99 per endpoint), or both.")
+	flagset.DurationVar(&dropStaticAfter, "drop-static-after", 0, "Stop sending a series once its value didn't change for this long, until it changes again. 0 disables it.")
+	flagset.BoolVar(&dropStaticStaleMarker, "drop-static-stale-marker", false, "Send a staleness marker for series stopped by -drop-static-after.")
+	flagset.StringVar(&tenantLabel, "tenant-label", "", "Take each series' tenant from this label and send one request per tenant. Labels starting with __ are removed from the series.")
+	flagset.StringVar(&defaultTenant, "default-tenant", "default", "Tenant of series without -tenant-label.")
+	flagset.StringVar(&tenantHeader, "tenant-header", "X-Scope-OrgID", "Header carrying the tenant taken from -tenant-label. Empty disables it.")
+	flagset.StringVar(&reservedLabelsPolicy, "reserved-labels", reservedLabelsOff, "What to do with labels starting with __ other than __name__: drop them, rename them without the underscores, fail the push, or off to send them as they are.")
+	flagset.BoolVar(&followRedirects, "follow-redirects", false, "Follow redirects from the remote write endpoint, resending the same method, body and headers. Off, a redirect fails the push.")
+	flagset.IntVar(&maxRedirects, "max-redirects", 10, "How many redirects -follow-redirects follows for one push.")
+	flagset.BoolVar(&cacheLabels, "cache-labels", false, "Reuse the converted, sorted labels of series that were already there in the previous gather.")
+	flagset.BoolVar(&enableAdmin, "enable-admin", false, "Enable endpoints exposing internal details, e.g. /healthz/detail.")
 	flagset.Parse(os.Args[1:])
+	rand.Seed(time.Now().UnixNano())
 
-	r := prometheus.NewRegistry()
-	r.MustRegister(httpRequestsTotal)
-	r.MustRegister(version)
-	r.MustRegister(alert)
-	r.MustRegister(testSummary)
+	if roundValuesDecimals > maxRoundValuesDecimals {
+		log.Fatalf("invalid -round-values-decimals %d, must be at most %d", roundValuesDecimals, maxRoundValuesDecimals)
+	}
+	if sampleFraction < 0 || sampleFraction > 1 {
+		log.Fatalf("invalid -sample-fraction %v, must be between 0 and 1", sampleFraction)
+	}
+	switch pushLatencyMetrics {
+	case "histogram", "summary", "both":
+	default:
+		log.Fatalf("invalid -push-latency-metrics %q, must be histogram, summary or both", pushLatencyMetrics)
+	}
+	if sink == "memory" && memorySinkCapacity < 1 {
+		log.Fatalf("invalid -memory-sink-capacity %d, must be at least 1", memorySinkCapacity)
+	}
+	if pushInterval <= 0 {
+		log.Fatalf("invalid -push-interval %s, must be positive", pushInterval)
+	}
+	if adaptiveInterval && maxPushInterval < pushInterval {
+		log.Fatalf("-max-push-interval=%s must not be shorter than -push-interval=%s", maxPushInterval, pushInterval)
+	}
+	if err := requestCodec.validateLevel(compressionLevel); err != nil {
+		log.Fatal(err)
+	}
+	switch histogramPolicy {
+	case histogramPolicyOff, histogramPolicyRepair, histogramPolicyReject:
+	default:
+		log.Fatalf("invalid -histogram-policy %q, must be off, repair or reject", histogramPolicy)
+	}
+	switch reservedLabelsPolicy {
+	case reservedLabelsOff, reservedLabelsDrop, reservedLabelsRename, reservedLabelsFail:
+	default:
+		log.Fatalf("invalid -reserved-labels %q, must be off, drop, rename or fail", reservedLabelsPolicy)
+	}
+	if counterTemporality != "cumulative" && counterTemporality != "delta" {
+		log.Fatalf("invalid -counter-temporality %q, must be cumulative or delta", counterTemporality)
+	}
+
+	if instanceLabel != "" && instanceID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Fatal(err)
+		}
+		instanceID = hostname
+	}
+
+	r := newRegistry(registerDemoMetrics)
+
+	ir := newInternalRegistry()
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -86,27 +410,41 @@ func main() {
 
 	http.Handle("/", promhttp.InstrumentHandlerCounter(httpRequestsTotal, handler))
 	http.Handle("/err", promhttp.InstrumentHandlerCounter(httpRequestsTotal, notfound))
-	http.Handle("/alert/set", setAlert)
-	http.Handle("/alert/unset", unSetAlert)
+	if registerDemoMetrics {
+		http.Handle("/alert/set", setAlert)
+		http.Handle("/alert/unset", unSetAlert)
+	}
 
-	http.Handle("/metrics", promhttp.HandlerFor(r, promhttp.HandlerOpts{}))
+	all := prometheus.Gatherers{r, ir}
+	http.Handle("/metrics", promhttp.HandlerFor(all, promhttp.HandlerOpts{}))
+	http.Handle("/internal-metrics", promhttp.HandlerFor(ir, promhttp.HandlerOpts{}))
+	http.HandleFunc("/healthz", healthzHandler)
+	if enableAdmin {
+		http.HandleFunc("/healthz/detail", healthzDetailHandler)
+	}
 
 	// remote write part
-	u, err := url.Parse("http://192.168.99.100:30080/api/prom/push")
+	// With -tenant-label, a {tenant} placeholder is filled in per request,
+	// the default tenant standing in wherever a single URL is needed.
+	tenant := tenantID
+	perTenantURL := tenantLabel != "" && tenantID == "" && strings.Contains(remoteWriteURL, tenantPlaceholder)
+	if perTenantURL {
+		tenant = defaultTenant
+	}
+	rawURL, err := expandTenantURL(remoteWriteURL, tenant)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	dur, err := model.ParseDuration("50s")
+	u, err := url.Parse(rawURL)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	conf := remote.ClientConfig{
 		URL: &config_util.URL{
-			u,
+			URL: u,
 		},
-		Timeout: dur,
+		Timeout: model.Duration(pushTimeout),
 		HTTPClientConfig: config_util.HTTPClientConfig{
 			TLSConfig: config_util.TLSConfig{
 				InsecureSkipVerify: true,
@@ -114,14 +452,108 @@ func main() {
 		},
 	}
 
-	cl, err := remote.NewClient(0, &conf)
-	if err != nil {
-		log.Fatal(err)
+	if socks5Proxy != "" {
+		proxyURL, err := parseSOCKS5URL(socks5Proxy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		conf.HTTPClientConfig.ProxyURL = config_util.URL{URL: proxyURL}
 	}
+
+	var (
+		cl         writeClient
+		memorySink *MemorySink
+	)
+	switch sink {
+	case "http":
+		hc, err := newHTTPWriteClient(u.String(), conf.HTTPClientConfig, pushTimeout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if perTenantURL {
+			hc.tenantURL = remoteWriteURL
+		}
+		cl = hc
+	case "memory":
+		memorySink = NewMemorySink(memorySinkCapacity)
+		cl = memorySink
+	default:
+		log.Fatalf("invalid -sink %q, must be http or memory", sink)
+	}
+	if teeFile != "" {
+		tee, err = newTeeWriter(teeFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var externalLabels *externalLabelsFile
+	if externalLabelsPath != "" {
+		externalLabels, err = newExternalLabelsFile(externalLabelsPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	pushed := prometheus.Gatherer(r)
+	if pushInternalMetrics {
+		pushed = all
+	}
+	p := newPipeline(pushed, externalLabels)
+	if verifyWrites > 0 {
+		if remoteReadURL == "" {
+			log.Fatal("-verify-writes requires -remote-read-url")
+		}
+		readURL, err := url.Parse(remoteReadURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		readConf := conf
+		readConf.URL = &config_util.URL{URL: readURL}
+		reader, err := remote.NewClient(1, &readConf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		p.verifier = newWriteVerifier(reader, verifyWrites, verifyWritesSeries)
+	}
+	pause.set(startPaused)
+	if startPaused && !enableAdmin {
+		log.Println("-start-paused without -enable-admin: pushing can't be resumed")
+	}
+	if enableAdmin {
+		http.Handle("/admin/preview", previewHandler(p))
+		http.Handle("/admin/pause", pauseHandler(true))
+		http.Handle("/admin/resume", pauseHandler(false))
+		if memorySink != nil {
+			http.Handle("/admin/memory-sink", memorySinkHandler(memorySink))
+		}
+	}
+
 	stopCh := make(chan struct{})
 	ctx := context.Background()
 
-	go remoteWrite(cl, ctx, r, stopCh)
+	if sink == "http" {
+		readiness, err := newReadinessProbe(u.String(), conf.HTTPClientConfig, pushTimeout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if tenantLabel != "" && tenantHeader != "" {
+			// Receivers requiring a tenant reject requests without one.
+			readiness.headers = map[string]string{tenantHeader: defaultTenant}
+		}
+		http.Handle("/readyz", readiness)
+		go readiness.run(readinessProbeInterval, stopCh)
+	} else {
+		http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+			if pausedNotReady && pause.isPaused() {
+				http.Error(w, "not ready: paused", http.StatusServiceUnavailable)
+				return
+			}
+			healthzHandler(w, r)
+		})
+	}
+
+	go remoteWrite(cl, ctx, p, stopCh)
 
 	fmt.Println("running server..........")
 	if err := http.ListenAndServe(bind, nil); err != nil {
@@ -132,47 +564,147 @@ func main() {
 	}
 }
 
-// It will write data in every 5s
-func remoteWrite(cl *remote.Client, ctx context.Context, r prometheus.Gatherer, stopCh chan struct{}) {
+// newRegistry returns the registry of the metrics being forwarded. The demo
+// alert and hello_world metrics are only registered with registerDemoMetrics.
+func newRegistry(registerDemoMetrics bool) *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(httpRequestsTotal)
+	r.MustRegister(version)
+	r.MustRegister(processStartTime)
+	r.MustRegister(processUptime)
+	if registerDemoMetrics {
+		r.MustRegister(alert)
+		r.MustRegister(testSummary)
+	}
+	return r
+}
+
+// newInternalRegistry returns the registry of the pusher's own metrics. They
+// live apart from the metrics being forwarded and share a prefix, so they are
+// easy to tell apart.
+func newInternalRegistry() *prometheus.Registry {
+	ir := prometheus.NewRegistry()
+	internal := prometheus.Registerer(ir)
+	if internalMetricsPrefix != "" {
+		internal = prometheus.WrapRegistererWithPrefix(internalMetricsPrefix+"_", ir)
+	}
+	internal.MustRegister(labelsPerSeries)
+	internal.MustRegister(outOfOrderSamples)
+	internal.MustRegister(droppedOverCeiling)
+	internal.MustRegister(oversizedSeries)
+	internal.MustRegister(batchAttempts)
+	internal.MustRegister(batchOutcomes)
+	internal.MustRegister(pushTimeouts)
+	if pushLatencyMetrics != "summary" {
+		internal.MustRegister(pushDurationHistogram)
+	}
+	if pushLatencyMetrics != "histogram" {
+		internal.MustRegister(pushDurationSummary)
+	}
+	internal.MustRegister(compressionErrors)
+	internal.MustRegister(compressionRatio)
+	internal.MustRegister(truncatedLabelValues)
+	internal.MustRegister(endpointUp)
+	internal.MustRegister(bytesSent)
+	internal.MustRegister(bytesBudgetRemaining)
+	internal.MustRegister(verificationFailures)
+	internal.MustRegister(labelValueCardinality)
+	internal.MustRegister(droppedSamples)
+	internal.MustRegister(histogramRepairs)
+	internal.MustRegister(reservedLabels)
+	internal.MustRegister(nextPushTimestamp)
+	internal.MustRegister(effectivePushInterval)
+	internal.MustRegister(pushIntervalAdaptations)
+	internal.MustRegister(teeErrors)
+	return ir
+}
+
+// It will write data in every pushInterval
+func remoteWrite(cl writeClient, ctx context.Context, p *pipeline, stopCh chan struct{}) {
+	delay := startupDelay
+	if startupDelayJitter && delay > 0 {
+		delay = time.Duration(rand.Int63n(int64(delay)))
+	}
+	if delay > 0 {
+		log.Printf("delaying first push by %s", delay)
+		select {
+		case <-time.After(delay):
+		case <-stopCh:
+			return
+		}
+	}
+
+	var budget *byteBudget
+	if maxBytesPerInterval > 0 {
+		budget = newByteBudget(maxBytesPerInterval)
+	}
+
+	var pending []batch
 	for {
+		interval := cadence.current()
+		scheduleNextPush(interval)
 		select {
-		case <-time.After(5 * time.Second):
-			mfs, err := r.Gather()
-			if err != nil {
-				log.Println(err)
+		case <-time.After(interval):
+			if pause.isPaused() {
+				if pausedGather {
+					if _, err := p.collect(true); err != nil {
+						log.Println(err)
+					}
+				}
 				continue
 			}
 
-			samples, err := metricFamilyToTimeseries(mfs)
-			if err != nil {
-				log.Println(err)
-				continue
+			deadline := time.Now().Add(interval - interval/10)
+			if budget != nil {
+				budget.reset()
 			}
 
-			req, err := buildWriteRequest(samples)
-			if err != nil {
-				log.Println(err)
-				continue
+			if len(pending) > maxDeferredBatches {
+				log.Printf("dropping %d deferred batches", len(pending)-maxDeferredBatches)
+				batchOutcomes.WithLabelValues("dropped").Add(float64(len(pending) - maxDeferredBatches))
+				for _, b := range pending[:len(pending)-maxDeferredBatches] {
+					droppedSamples.WithLabelValues(dropReasonBudget).Add(float64(countSamples(b.samples)))
+				}
+				pending = pending[len(pending)-maxDeferredBatches:]
 			}
 
-			err = cl.Store(ctx, req)
+			batches, err := nextBatches(p)
 			if err != nil {
 				log.Println(err)
-				continue
+			}
+			for _, b := range batches {
+				if budget != nil && len(b.data) > budget.max {
+					log.Printf("dropping batch of %d bytes, it exceeds -max-bytes-per-interval=%d", len(b.data), budget.max)
+					batchOutcomes.WithLabelValues("dropped").Inc()
+					droppedSamples.WithLabelValues(dropReasonBudget).Add(float64(countSamples(b.samples)))
+					continue
+				}
+				pending = append(pending, b)
 			}
 
-			fmt.Println("pushed data....")
+			pending = sendBatches(ctx, cl, p, budget, pending, deadline, stopCh)
+			cadence.adapt()
 		case <-stopCh:
 			return
 		}
 	}
 }
 
-func metricFamilyToTimeseries(mfs []*dto.MetricFamily) ([]prompb.TimeSeries, error) {
+// metricFamilyToTimeseries converts the gathered metrics into series, with
+// now as the timestamp of samples that don't have their own.
+func metricFamilyToTimeseries(mfs []*dto.MetricFamily, now model.Time) ([]prompb.TimeSeries, error) {
 	ts := []prompb.TimeSeries{}
+	var in interner
+	if internLabels {
+		in = interner{}
+	}
+	if cacheLabels {
+		labelsCache.begin()
+		defer labelsCache.end()
+	}
 	for _, mf := range mfs {
 		vec, err := expfmt.ExtractSamples(&expfmt.DecodeOptions{
-			model.Now(),
+			Timestamp: now,
 		}, mf)
 		if err != nil {
 			return nil, err
@@ -180,12 +712,22 @@ func metricFamilyToTimeseries(mfs []*dto.MetricFamily) ([]prompb.TimeSeries, err
 
 		for _, s := range vec {
 			if s != nil {
+				timestamp := int64(s.Timestamp)
+				if timestampLabel != "" {
+					timestamp = timestampFromLabel(s.Metric, timestampLabel, timestamp)
+				}
+				var labels []prompb.Label
+				if cacheLabels {
+					labels = labelsCache.labels(s.Metric, in)
+				} else {
+					labels = metricToLabels(s.Metric, in)
+				}
 				ts = append(ts, prompb.TimeSeries{
-					Labels: metricToLabels(s.Metric),
+					Labels: labels,
 					Samples: []prompb.Sample{
 						{
-							Value:     float64(s.Value),
-							Timestamp: int64(s.Timestamp),
+							Value:     roundValue(float64(s.Value), roundValuesDecimals),
+							Timestamp: timestamp,
 						},
 					},
 				})
@@ -195,12 +737,48 @@ func metricFamilyToTimeseries(mfs []*dto.MetricFamily) ([]prompb.TimeSeries, err
 	return ts, nil
 }
 
-func metricToLabels(m model.Metric) []prompb.Label {
+// timestampFromLabel removes the label from m and returns its value parsed as
+// a unix millisecond timestamp. If the label is missing or malformed, def is
+// returned.
+func timestampFromLabel(m model.Metric, label string, def int64) int64 {
+	v, ok := m[model.LabelName(label)]
+	if !ok {
+		return def
+	}
+	delete(m, model.LabelName(label))
+
+	t, err := strconv.ParseInt(string(v), 10, 64)
+	if err != nil {
+		log.Printf("ignoring malformed timestamp label %s=%q on %s: %v", label, v, m, err)
+		return def
+	}
+	return t
+}
+
+// maxRoundValuesDecimals is the most decimal places values can be rounded
+// to, as 10^308 is the largest power of ten a float64 holds.
+const maxRoundValuesDecimals = 308
+
+// roundValue rounds v to the given number of decimal places. Negative
+// decimals leave v untouched, as do values too large to be scaled.
+func roundValue(v float64, decimals int) float64 {
+	if decimals < 0 || decimals > maxRoundValuesDecimals || math.IsNaN(v) || math.IsInf(v, 0) {
+		return v
+	}
+	p := math.Pow(10, float64(decimals))
+	scaled := v * p
+	if math.IsInf(scaled, 0) {
+		return v
+	}
+	return math.Round(scaled) / p
+}
+
+func metricToLabels(m model.Metric, in interner) []prompb.Label {
 	lables := []prompb.Label{}
 	for k, v := range m {
 		lables = append(lables, prompb.Label{
-			Name:  string(k),
-			Value: string(v),
+			Name:  in.intern(string(k)),
+			Value: in.intern(string(v)),
 		})
 	}
 	return lables
@@ -208,6 +786,10 @@ func metricToLabels(m model.Metric) []prompb.Label {
 
 // https://github.com/prometheus/prometheus/blob/84df210c410a0684ec1a05479bfa54458562695e/storage/remote/queue_manager.go#L759
 func buildWriteRequest(samples []prompb.TimeSeries) ([]byte, error) {
+	if poolWriteRequests {
+		return buildPooledWriteRequest(samples)
+	}
+
 	req := &prompb.WriteRequest{
 		Timeseries: samples,
 	}
@@ -217,6 +799,5 @@ func buildWriteRequest(samples []prompb.TimeSeries) ([]byte, error) {
 		return nil, err
 	}
 
-	compressed := snappy.Encode(nil, data)
-	return compressed, nil
+	return compress(data)
 }
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// setInstance sets the instance label value for a test and returns a function
+// restoring the previous one.
+func setInstance(id string) func() {
+	prev := instanceID
+	instanceID = id
+	return func() { instanceID = prev }
+}
+
+// gatherSeries gathers g and converts the result with a timestamp of 1000.
+func gatherSeries(t testing.TB, g prometheus.Gatherer) []prompb.TimeSeries {
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	series, err := metricFamilyToTimeseries(mfs, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return series
+}
+
+// metricValue returns the current value of a counter or gauge.
+func metricValue(m prometheus.Metric) float64 {
+	var pb dto.Metric
+	if err := m.Write(&pb); err != nil {
+		panic(err)
+	}
+	switch {
+	case pb.Counter != nil:
+		return pb.Counter.GetValue()
+	case pb.Gauge != nil:
+		return pb.Gauge.GetValue()
+	case pb.Untyped != nil:
+		return pb.Untyped.GetValue()
+	}
+	panic("metricValue: not a counter or gauge")
+}
+
+func TestRoundValue(t *testing.T) {
+	for _, c := range []struct {
+		v        float64
+		decimals int
+		want     float64
+	}{
+		{1.23456, 2, 1.23},
+		{1.235001, 2, 1.24},
+		{-1.23456, 3, -1.235},
+		{0.1 + 0.2, 10, 0.3},
+		{123.456, 0, 123},
+		{1.23456, -1, 1.23456},
+		{math.MaxFloat64, 2, math.MaxFloat64},
+		{1.5, maxRoundValuesDecimals + 1, 1.5},
+		{math.Inf(1), 2, math.Inf(1)},
+	} {
+		if got := roundValue(c.v, c.decimals); got != c.want {
+			t.Errorf("roundValue(%v, %d) = %v, want %v", c.v, c.decimals, got, c.want)
+		}
+	}
+	if got := roundValue(math.NaN(), 2); !math.IsNaN(got) {
+		t.Errorf("roundValue(NaN, 2) = %v", got)
+	}
+}
+
+func TestMetricFamilyToTimeseriesRounding(t *testing.T) {
+	defer func(prev int) { roundValuesDecimals = prev }(roundValuesDecimals)
+	roundValuesDecimals = 2
+
+	r := prometheus.NewRegistry()
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_noisy", Help: "test"})
+	r.MustRegister(g)
+	g.Set(0.1 + 0.2 + 1e-15)
+
+	series := gatherSeries(t, r)
+	if len(series) != 1 {
+		t.Fatalf("got %d series, want 1", len(series))
+	}
+	if v := series[0].Samples[0].Value; v != 0.3 {
+		t.Errorf("got %v, want 0.3", v)
+	}
+}
+
+func TestTimestampFromLabel(t *testing.T) {
+	defer func(prev string) { timestampLabel = prev }(timestampLabel)
+	timestampLabel = "ts"
+
+	r := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_timed", Help: "test"}, []string{"case", "ts"})
+	r.MustRegister(g)
+	g.WithLabelValues("valid", "1234567").Set(1)
+	g.WithLabelValues("malformed", "yesterday").Set(2)
+	r.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_untimed", Help: "test"}))
+
+	got := map[string]int64{}
+	for _, s := range gatherSeries(t, r) {
+		if v := labelValue(s.Labels, "ts"); v != "" {
+			t.Errorf("series %s still has the timestamp label", seriesKey(s.Labels))
+		}
+		name := labelValue(s.Labels, "case")
+		if name == "" {
+			name = labelValue(s.Labels, "__name__")
+		}
+		got[name] = s.Samples[0].Timestamp
+	}
+	want := map[string]int64{
+		"valid":        1234567,
+		"malformed":    1000,
+		"test_untimed": 1000,
+	}
+	for name, ts := range want {
+		if got[name] != ts {
+			t.Errorf("%s: got timestamp %d, want %d", name, got[name], ts)
+		}
+	}
+}
+
+func TestDemoMetricsRegistration(t *testing.T) {
+	// hello_world is a summary without observations, which is exposed too.
+	for _, register := range []bool{true, false} {
+		rec := httptest.NewRecorder()
+		promhttp.HandlerFor(newRegistry(register), promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		body := rec.Body.String()
+		for _, name := range []string{"\nalert{", "\nhello_world_count{"} {
+			if got := strings.Contains(body, name); got != register {
+				t.Errorf("-register-demo-metrics=%v: %s exposed: %v", register, strings.TrimSpace(name), got)
+			}
+		}
+		if !strings.Contains(body, "\nprocess_start_time_seconds ") {
+			t.Errorf("-register-demo-metrics=%v: process_start_time_seconds is missing", register)
+		}
+	}
+}
+
+func TestInternalMetricsEndpoint(t *testing.T) {
+	defer func(prev string) { internalMetricsPrefix = prev }(internalMetricsPrefix)
+
+	for _, prefix := range []string{"rwdemo", ""} {
+		internalMetricsPrefix = prefix
+		want := "remote_write_timeouts_total "
+		if prefix != "" {
+			want = prefix + "_" + want
+		}
+
+		rec := httptest.NewRecorder()
+		promhttp.HandlerFor(newInternalRegistry(), promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest("GET", "/internal-metrics", nil))
+		body := rec.Body.String()
+		if !strings.Contains(body, "\n"+want) {
+			t.Errorf("-internal-metrics-prefix=%q: %s is missing", prefix, strings.TrimSpace(want))
+		}
+		if strings.Contains(body, "\nalert{") {
+			t.Errorf("-internal-metrics-prefix=%q: alert is exposed", prefix)
+		}
+
+		internal := map[string]bool{}
+		for _, s := range gatherSeries(t, newInternalRegistry()) {
+			internal[labelValue(s.Labels, "__name__")] = true
+		}
+		for _, s := range gatherSeries(t, newRegistry(true)) {
+			if name := labelValue(s.Labels, "__name__"); internal[name] {
+				t.Errorf("-internal-metrics-prefix=%q: forwarded metric %s is exposed", prefix, name)
+			}
+		}
+	}
+}
+
+func TestInternalMetricsPrefix(t *testing.T) {
+	defer func(prefix, latency string) {
+		internalMetricsPrefix, pushLatencyMetrics = prefix, latency
+	}(internalMetricsPrefix, pushLatencyMetrics)
+	internalMetricsPrefix = "rwdemo"
+	pushLatencyMetrics = "both"
+
+	mfs, err := newInternalRegistry().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mfs) == 0 {
+		t.Fatal("no internal metrics gathered")
+	}
+	for _, mf := range mfs {
+		if name := mf.GetName(); !strings.HasPrefix(name, internalMetricsPrefix+"_") {
+			t.Errorf("internal metric %s lacks the %s_ prefix", name, internalMetricsPrefix)
+		}
+	}
+}
+
+func TestProcessStartTime(t *testing.T) {
+	now := float64(time.Now().UnixNano()) / 1e9
+	start := metricValue(processStartTime)
+	if start > now || start < now-3600 {
+		t.Errorf("process_start_time_seconds = %v, want shortly before %v", start, now)
+	}
+	if uptime := metricValue(processUptime); uptime < 0 || uptime > now-start+1 {
+		t.Errorf("process_uptime_seconds = %v, want about %v", uptime, now-start)
+	}
+
+	found := map[string]bool{}
+	for _, s := range gatherSeries(t, newRegistry(false)) {
+		found[labelValue(s.Labels, "__name__")] = true
+	}
+	for _, name := range []string{"process_start_time_seconds", "process_uptime_seconds"} {
+		if !found[name] {
+			t.Errorf("%s isn't gathered", name)
+		}
+	}
+}
+
+// recordingClient is a writeClient recording when requests were stored.
+type recordingClient struct {
+	mu     sync.Mutex
+	stores []time.Time
+	stored chan struct{}
+}
+
+func newRecordingClient() *recordingClient {
+	return &recordingClient{stored: make(chan struct{}, 100)}
+}
+
+func (c *recordingClient) Store(ctx context.Context, req []byte) error {
+	c.mu.Lock()
+	c.stores = append(c.stores, time.Now())
+	c.mu.Unlock()
+	select {
+	case c.stored <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (c *recordingClient) Name() string { return "recording" }
+
+// startRemoteWrite runs the push loop with a short interval until the
+// returned function is called, which waits for the loop to return.
+func startRemoteWrite(cl writeClient, p *pipeline) func() {
+	prevInterval, prevCadence := pushInterval, cadence
+	pushInterval = 10 * time.Millisecond
+	cadence = &pushCadence{}
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		remoteWrite(cl, context.Background(), p, stopCh)
+		close(done)
+	}()
+	return func() {
+		close(stopCh)
+		<-done
+		pushInterval, cadence = prevInterval, prevCadence
+	}
+}
+
+func TestStartupDelay(t *testing.T) {
+	defer func(prev time.Duration) { startupDelay = prev }(startupDelay)
+	startupDelay = 200 * time.Millisecond
+
+	cl := newRecordingClient()
+	start := time.Now()
+	stop := startRemoteWrite(cl, newPipeline(prometheus.NewRegistry(), nil))
+	defer stop()
+
+	select {
+	case <-cl.stored:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was pushed")
+	}
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if d := cl.stores[0].Sub(start); d < startupDelay {
+		t.Errorf("first push after %s, before the %s startup delay", d, startupDelay)
+	}
+}
+
+func TestStartupDelayStop(t *testing.T) {
+	defer func(prev time.Duration) { startupDelay = prev }(startupDelay)
+	startupDelay = time.Hour
+
+	cl := newRecordingClient()
+	stop := startRemoteWrite(cl, newPipeline(prometheus.NewRegistry(), nil))
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("push loop didn't stop during the startup delay")
+	}
+	if len(cl.stores) != 0 {
+		t.Errorf("pushed %d times during the startup delay", len(cl.stores))
+	}
+}
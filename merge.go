@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// mergeSeries coalesces series with identical label sets into one, keeping
+// the position of the first occurrence. The combined samples are sorted by
+// timestamp and samples sharing a timestamp are collapsed into the last one.
+func mergeSeries(series []prompb.TimeSeries) []prompb.TimeSeries {
+	index := make(map[string]int, len(series))
+	out := series[:0]
+	for _, s := range series {
+		key := seriesKey(s.Labels)
+		if i, ok := index[key]; ok {
+			out[i].Samples = append(out[i].Samples, s.Samples...)
+			continue
+		}
+		index[key] = len(out)
+		out = append(out, s)
+	}
+
+	for i := range out {
+		out[i].Samples = dedupSamples(out[i].Samples)
+	}
+	return out
+}
+
+func dedupSamples(samples []prompb.Sample) []prompb.Sample {
+	if len(samples) < 2 {
+		return samples
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Timestamp < samples[j].Timestamp
+	})
+
+	out := samples[:1]
+	for _, s := range samples[1:] {
+		if s.Timestamp == out[len(out)-1].Timestamp {
+			out[len(out)-1] = s
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
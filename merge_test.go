@@ -0,0 +1,27 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestMergeSeries(t *testing.T) {
+	a := []prompb.Label{{Name: "__name__", Value: "a"}}
+	b := []prompb.Label{{Name: "__name__", Value: "b"}}
+	got := mergeSeries([]prompb.TimeSeries{
+		{Labels: a, Samples: []prompb.Sample{{Value: 3, Timestamp: 3000}}},
+		{Labels: b, Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}}},
+		{Labels: a, Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}}},
+		{Labels: a, Samples: []prompb.Sample{{Value: 2, Timestamp: 2000}, {Value: 4, Timestamp: 3000}}},
+	})
+
+	want := []prompb.TimeSeries{
+		{Labels: a, Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}, {Value: 2, Timestamp: 2000}, {Value: 4, Timestamp: 3000}}},
+		{Labels: b, Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
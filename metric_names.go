@@ -0,0 +1,90 @@
+package main
+
+import (
+	"hash/fnv"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// metricNameCap limits the number of distinct metric names sent per push.
+// The kept names are the ones with the lowest hashes, so the same names are
+// kept on every push.
+type metricNameCap struct {
+	max int
+
+	mu     sync.Mutex
+	warned map[string]bool
+}
+
+func newMetricNameCap(max int) *metricNameCap {
+	return &metricNameCap{
+		max:    max,
+		warned: map[string]bool{},
+	}
+}
+
+// apply drops the series of the names over the cap. A dry run doesn't log
+// them.
+func (c *metricNameCap) apply(series []prompb.TimeSeries, dryRun bool) []prompb.TimeSeries {
+	names := map[string]uint64{}
+	for _, s := range series {
+		name := labelValue(s.Labels, model.MetricNameLabel)
+		if _, ok := names[name]; !ok {
+			h := fnv.New64a()
+			h.Write([]byte(name))
+			names[name] = h.Sum64()
+		}
+	}
+	if len(names) <= c.max {
+		return series
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if names[sorted[i]] != names[sorted[j]] {
+			return names[sorted[i]] < names[sorted[j]]
+		}
+		return sorted[i] < sorted[j]
+	})
+	dropped := map[string]bool{}
+	for _, name := range sorted[c.max:] {
+		dropped[name] = true
+	}
+	if !dryRun {
+		c.warn(sorted[c.max:])
+	}
+
+	out := series[:0]
+	for _, s := range series {
+		if !dropped[labelValue(s.Labels, model.MetricNameLabel)] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// warn logs the dropped names that weren't logged before.
+func (c *metricNameCap) warn(dropped []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var names []string
+	for _, name := range dropped {
+		if !c.warned[name] {
+			c.warned[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		log.Printf("more than -max-metric-names=%d metric names, dropping %s", c.max, strings.Join(names, ", "))
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func keptNames(series []prompb.TimeSeries) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, s := range series {
+		name := labelValue(s.Labels, "__name__")
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestMetricNameCap(t *testing.T) {
+	c := newMetricNameCap(3)
+	push := func(names []string) []string {
+		var series []prompb.TimeSeries
+		for _, name := range names {
+			series = append(series, testSeries(2, name)...)
+		}
+		out := c.apply(series, false)
+		for _, s := range out {
+			if len(s.Samples) != 1 {
+				t.Fatalf("series %s lost its sample", seriesKey(s.Labels))
+			}
+		}
+		if got := len(out); got != 6 {
+			t.Errorf("kept %d series, want both series of 3 names", got)
+		}
+		return keptNames(out)
+	}
+
+	var names []string
+	for i := 0; i < 10; i++ {
+		names = append(names, fmt.Sprintf("metric_%d", i))
+	}
+	first := push(names)
+	if len(first) != 3 {
+		t.Fatalf("kept names %v, want 3", first)
+	}
+
+	// The same names are kept whatever the order of the series.
+	reversed := make([]string, len(names))
+	for i, name := range names {
+		reversed[len(names)-1-i] = name
+	}
+	if got := push(reversed); fmt.Sprint(got) != fmt.Sprint(first) {
+		t.Errorf("kept %v on the next push, want %v", got, first)
+	}
+	if len(c.warned) != 7 {
+		t.Errorf("warned about %d names, want the 7 dropped", len(c.warned))
+	}
+
+	if got := c.apply(testSeries(5, "single"), false); len(got) != 5 {
+		t.Errorf("kept %d of 5 series under the cap", len(got))
+	}
+}
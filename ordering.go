@@ -0,0 +1,109 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var outOfOrderSamples = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "remote_write_out_of_order_samples_total",
+	Help: "Samples dropped because they are older than a sample already sent for the same series",
+})
+
+// watermarkRetention is the number of cycles a series can be absent before
+// its high-watermark is forgotten.
+const watermarkRetention = 60
+
+// seriesOrderer makes sure samples of a series are never sent with a
+// timestamp lower than one that was already sent for that series.
+type seriesOrderer struct {
+	mu         sync.Mutex
+	watermarks map[string]int64
+	// lastSeen holds the cycle in which each series was last collected.
+	lastSeen map[string]int
+	cycle    int
+}
+
+func newSeriesOrderer() *seriesOrderer {
+	return &seriesOrderer{
+		watermarks: map[string]int64{},
+		lastSeen:   map[string]int{},
+	}
+}
+
+// filter sorts the samples of every series by timestamp and drops the ones
+// older than the series' high-watermark. Series left without samples are
+// removed. The watermarks of series not collected for watermarkRetention
+// cycles are forgotten. A dry run doesn't count the dropped samples nor start
+// a new cycle.
+func (o *seriesOrderer) filter(series []prompb.TimeSeries, dryRun bool) []prompb.TimeSeries {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !dryRun {
+		o.cycle++
+	}
+	out := series[:0]
+	for _, s := range series {
+		sort.SliceStable(s.Samples, func(i, j int) bool {
+			return s.Samples[i].Timestamp < s.Samples[j].Timestamp
+		})
+
+		key := seriesKey(s.Labels)
+		if !dryRun {
+			o.lastSeen[key] = o.cycle
+		}
+		wm, seen := o.watermarks[key]
+		samples := s.Samples[:0]
+		for _, sample := range s.Samples {
+			if seen && sample.Timestamp < wm {
+				if !dryRun {
+					outOfOrderSamples.Inc()
+				}
+				continue
+			}
+			samples = append(samples, sample)
+		}
+		if len(samples) == 0 {
+			continue
+		}
+		s.Samples = samples
+		out = append(out, s)
+	}
+	if !dryRun {
+		o.evict()
+	}
+	return out
+}
+
+// evict forgets the series not collected for watermarkRetention cycles.
+func (o *seriesOrderer) evict() {
+	for key, cycle := range o.lastSeen {
+		if o.cycle-cycle >= watermarkRetention {
+			delete(o.lastSeen, key)
+			delete(o.watermarks, key)
+		}
+	}
+}
+
+// commit raises the high-watermarks to the newest samples of series that were
+// sent successfully, and marks them as seen in the current cycle.
+func (o *seriesOrderer) commit(series []prompb.TimeSeries) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, s := range series {
+		if len(s.Samples) == 0 {
+			continue
+		}
+		key := seriesKey(s.Labels)
+		o.lastSeen[key] = o.cycle
+		last := s.Samples[len(s.Samples)-1].Timestamp
+		if wm, ok := o.watermarks[key]; !ok || last > wm {
+			o.watermarks[key] = last
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func orderedSeries(name string, timestamps ...int64) prompb.TimeSeries {
+	s := prompb.TimeSeries{Labels: []prompb.Label{{Name: "__name__", Value: name}}}
+	for _, ts := range timestamps {
+		s.Samples = append(s.Samples, prompb.Sample{Value: float64(ts), Timestamp: ts})
+	}
+	return s
+}
+
+func TestSeriesOrdererInterleaved(t *testing.T) {
+	o := newSeriesOrderer()
+
+	first := o.filter([]prompb.TimeSeries{orderedSeries("a", 3000, 1000, 2000)}, false)
+	if len(first) != 1 || len(first[0].Samples) != 3 {
+		t.Fatalf("first push: got %v", first)
+	}
+	for i, want := range []int64{1000, 2000, 3000} {
+		if got := first[0].Samples[i].Timestamp; got != want {
+			t.Errorf("sample %d has timestamp %d, want %d", i, got, want)
+		}
+	}
+	o.commit(first)
+
+	before := metricValue(outOfOrderSamples)
+	second := o.filter([]prompb.TimeSeries{
+		orderedSeries("a", 2500, 4000, 1500),
+		orderedSeries("b", 500),
+	}, false)
+	if len(second) != 2 {
+		t.Fatalf("second push: got %d series, want 2", len(second))
+	}
+	if got := second[0].Samples; len(got) != 1 || got[0].Timestamp != 4000 {
+		t.Errorf("series a: got samples %v, want only the one at 4000", got)
+	}
+	if got := metricValue(outOfOrderSamples) - before; got != 2 {
+		t.Errorf("counted %v out-of-order samples, want 2", got)
+	}
+
+	// A series whose samples are all older than its watermark is removed.
+	if got := o.filter([]prompb.TimeSeries{orderedSeries("a", 2000)}, true); len(got) != 0 {
+		t.Errorf("got %v, want no series", got)
+	}
+}
+
+func TestSeriesOrdererEvictsAbsentSeries(t *testing.T) {
+	o := newSeriesOrderer()
+	o.commit(o.filter([]prompb.TimeSeries{orderedSeries("gone", 1000), orderedSeries("kept", 1000)}, false))
+
+	for i := 0; i < watermarkRetention; i++ {
+		o.filter([]prompb.TimeSeries{orderedSeries("kept", 1000)}, false)
+	}
+	if _, ok := o.watermarks[seriesKey(orderedSeries("gone").Labels)]; ok {
+		t.Error("watermark of a series absent for the retention is still tracked")
+	}
+	if _, ok := o.watermarks[seriesKey(orderedSeries("kept").Labels)]; !ok {
+		t.Error("watermark of a collected series was evicted")
+	}
+	if len(o.lastSeen) != 1 {
+		t.Errorf("tracking %d series, want 1", len(o.lastSeen))
+	}
+}
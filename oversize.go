@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var oversizedSeries = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "remote_write_oversized_series_total",
+	Help: "Series dropped because a request containing only that series exceeds -abort-if-request-exceeds",
+})
+
+// dropOversizedSeries removes every series that on its own would produce a
+// request body larger than maxBytes, as the receiver would certainly reject
+// it. A dry run neither logs nor counts the dropped series.
+func dropOversizedSeries(series []prompb.TimeSeries, maxBytes int, dryRun bool) []prompb.TimeSeries {
+	out := series[:0]
+	for _, s := range series {
+		size, err := singleSeriesRequestSize(s, maxBytes)
+		if err == nil && size <= maxBytes {
+			out = append(out, s)
+			continue
+		}
+		if dryRun {
+			continue
+		}
+		if err != nil {
+			log.Printf("dropping series %s: %v", seriesKey(s.Labels), err)
+		} else {
+			log.Printf("dropping series %s: request of %d bytes exceeds -abort-if-request-exceeds=%d", seriesKey(s.Labels), size, maxBytes)
+		}
+		oversizedSeries.Inc()
+	}
+	return out
+}
+
+// singleSeriesRequestSize returns the size of the request body carrying only
+// s, as sent with -wire-max-label-value-bytes. The request is only built if
+// its size can't be bounded from the uncompressed size.
+func singleSeriesRequestSize(s prompb.TimeSeries, maxBytes int) (int, error) {
+	series := []prompb.TimeSeries{s}
+	if wireMaxLabelValueBytes > 0 {
+		series = withoutLabel(series, "")
+		truncateLabelValues(series, wireMaxLabelValueBytes, wireTruncationMarker)
+	}
+	req := prompb.WriteRequest{Timeseries: series}
+	if bound := snappy.MaxEncodedLen(req.Size()); bound >= 0 && bound <= maxBytes {
+		return bound, nil
+	}
+	data, err := buildWriteRequest(req.Timeseries)
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
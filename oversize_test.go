@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestDropOversizedSeries(t *testing.T) {
+	blob := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(blob)
+	huge := prompb.TimeSeries{
+		Labels: []prompb.Label{
+			{Name: "__name__", Value: "huge"},
+			// Random data doesn't compress under the limit.
+			{Name: "blob", Value: hex.EncodeToString(blob)},
+		},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}
+	series := append(testSeries(3, "small"), huge)
+
+	before := metricValue(oversizedSeries)
+	got := dropOversizedSeries(series, 1024, false)
+	if len(got) != 3 {
+		t.Fatalf("kept %d series, want 3", len(got))
+	}
+	for _, s := range got {
+		if labelValue(s.Labels, "__name__") == "huge" {
+			t.Error("oversized series was kept")
+		}
+	}
+	if n := metricValue(oversizedSeries) - before; n != 1 {
+		t.Errorf("counted %v oversized series, want 1", n)
+	}
+
+	// Dropped before it's sent, the series can't be retried.
+	before = metricValue(oversizedSeries)
+	if got := dropOversizedSeries([]prompb.TimeSeries{huge}, 1024, true); len(got) != 0 {
+		t.Errorf("dry run kept %d series", len(got))
+	}
+	if n := metricValue(oversizedSeries) - before; n != 0 {
+		t.Errorf("dry run counted %v oversized series", n)
+	}
+}
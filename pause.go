@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+)
+
+// pauseState tells whether pushing is paused, e.g. during maintenance of the
+// receiver.
+type pauseState struct {
+	mu     sync.RWMutex
+	paused bool
+}
+
+var pause = &pauseState{}
+
+func (p *pauseState) set(paused bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = paused
+}
+
+func (p *pauseState) isPaused() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.paused
+}
+
+// pauseHandler sets the pause state on POST requests.
+func pauseHandler(paused bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		pause.set(paused)
+		w.WriteHeader(http.StatusOK)
+		if paused {
+			w.Write([]byte("Pushing paused."))
+		} else {
+			w.Write([]byte("Pushing resumed."))
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPauseAndResume(t *testing.T) {
+	defer pause.set(pause.isPaused())
+	pauseHandler(true).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/admin/pause", nil))
+	if !pause.isPaused() {
+		t.Fatal("POST /admin/pause didn't pause")
+	}
+
+	cl := newRecordingClient()
+	stop := startRemoteWrite(cl, newPipeline(prometheus.NewRegistry(), nil))
+	defer stop()
+
+	// A few push intervals go by without a push.
+	time.Sleep(100 * time.Millisecond)
+	cl.mu.Lock()
+	n := len(cl.stores)
+	cl.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("stored %d requests while paused", n)
+	}
+
+	rec := httptest.NewRecorder()
+	pauseHandler(false).ServeHTTP(rec, httptest.NewRequest("GET", "/admin/resume", nil))
+	if rec.Code != http.StatusMethodNotAllowed || !pause.isPaused() {
+		t.Errorf("GET /admin/resume: got status %d, paused %v", rec.Code, pause.isPaused())
+	}
+	pauseHandler(false).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/admin/resume", nil))
+	select {
+	case <-cl.stored:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was stored after resuming")
+	}
+}
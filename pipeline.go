@@ -0,0 +1,168 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// pipeline gathers metrics and turns them into the series that get sent,
+// applying every configured transformation.
+type pipeline struct {
+	gatherer       prometheus.Gatherer
+	externalLabels *externalLabelsFile
+	orderer        *seriesOrderer
+	ceiling        *seriesCeiling
+	verifier       *writeVerifier
+	deltas         *deltaTracker
+	cardinality    *labelCardinality
+	nameCap        *metricNameCap
+	static         *staticSeriesFilter
+}
+
+func newPipeline(g prometheus.Gatherer, externalLabels *externalLabelsFile) *pipeline {
+	p := &pipeline{
+		gatherer:       g,
+		externalLabels: externalLabels,
+	}
+	if strictOrdering {
+		p.orderer = newSeriesOrderer()
+	}
+	if maxTotalSeries > 0 {
+		p.ceiling = &seriesCeiling{max: maxTotalSeries}
+	}
+	if counterTemporality == "delta" {
+		p.deltas = newDeltaTracker()
+	}
+	if dropStaticAfter > 0 {
+		p.static = newStaticSeriesFilter(dropStaticAfter, dropStaticStaleMarker)
+	}
+	if maxMetricNames > 0 {
+		p.nameCap = newMetricNameCap(maxMetricNames)
+	}
+	if labelCardinalityMin > 0 {
+		p.cardinality = newLabelCardinality(labelCardinalityMin, labelCardinalityWindow)
+	}
+	return p
+}
+
+// collect runs one gather and conversion cycle. A dry run leaves the state
+// carried from one push to the next and the pusher's own metrics untouched.
+func (p *pipeline) collect(dryRun bool) ([]prompb.TimeSeries, error) {
+	mfs, err := p.gatherer.Gather()
+	health.gathered(err)
+	if err != nil {
+		return nil, err
+	}
+
+	p.dropped(dropReasonHistogram, checkHistograms(mfs, histogramPolicy, dryRun), dryRun)
+	// Every sample of the cycle, synthetic ones included, gets the same
+	// timestamp.
+	now := model.Now().Add(timestampOffset)
+	samples, err := metricFamilyToTimeseries(mfs, now)
+	if err != nil {
+		return nil, err
+	}
+	if emitTargetInfo {
+		samples = append(samples, targetInfo(now))
+	}
+	var external map[string]string
+	if p.externalLabels != nil {
+		external = p.externalLabels.current()
+		if emitExternalLabelsInfo {
+			samples = append(samples, externalLabelsInfo(external, now))
+		}
+	}
+	scraped := len(samples)
+	injectLabels(samples, external)
+	checked, err := checkReservedLabels(samples, reservedLabelsPolicy, dryRun)
+	if err != nil {
+		p.dropped(dropReasonReserved, countSamples(samples), dryRun)
+		return nil, err
+	}
+	samples = checked
+	if !dryRun {
+		// Observed once every label sent is there.
+		for _, s := range samples {
+			labelsPerSeries.Observe(float64(len(s.Labels)))
+		}
+	}
+	if mergeSameSeries {
+		samples = mergeSeries(samples)
+	}
+	if p.deltas != nil {
+		// Deltas are tracked under the final labels, so they can be
+		// committed once the series are delivered.
+		p.deltas.apply(samples, counterNames(mfs), !dryRun)
+	}
+	if sampleFraction < 1 {
+		n := countSamples(samples)
+		samples = sampleSeries(samples, sampleFraction)
+		p.dropped(dropReasonSampledOut, n-countSamples(samples), dryRun)
+	}
+	if p.nameCap != nil {
+		n := countSamples(samples)
+		samples = p.nameCap.apply(samples, dryRun)
+		p.dropped(dropReasonNameCap, n-countSamples(samples), dryRun)
+	}
+	if abortIfRequestExceeds > 0 {
+		n := countSamples(samples)
+		samples = dropOversizedSeries(samples, abortIfRequestExceeds, dryRun)
+		p.dropped(dropReasonOversized, n-countSamples(samples), dryRun)
+	}
+	if p.ceiling != nil {
+		n := countSamples(samples)
+		samples = p.ceiling.apply(samples, dryRun)
+		p.dropped(dropReasonCeiling, n-countSamples(samples), dryRun)
+	}
+	if p.static != nil {
+		n := countSamples(samples)
+		samples = p.static.apply(samples, !dryRun)
+		p.dropped(dropReasonStatic, n-countSamples(samples), dryRun)
+	}
+	if p.orderer != nil {
+		n := countSamples(samples)
+		samples = p.orderer.filter(samples, dryRun)
+		p.dropped(dropReasonOutOfOrder, n-countSamples(samples), dryRun)
+	}
+	if p.cardinality != nil && !dryRun {
+		p.cardinality.observe(samples)
+	}
+	if syntheticMetrics {
+		stats := scrapeSampleStats(scraped, len(samples), now)
+		injectLabels(stats, external)
+		samples = append(samples, stats...)
+	}
+	return samples, nil
+}
+
+// injectLabels adds the external labels and the instance label to series.
+func injectLabels(series []prompb.TimeSeries, external map[string]string) {
+	addLabels(series, external)
+	if instanceLabel != "" {
+		addLabels(series, map[string]string{instanceLabel: instanceID})
+	}
+}
+
+// dropped counts n samples dropped for reason, unless it's a dry run.
+func (p *pipeline) dropped(reason string, n int, dryRun bool) {
+	if dryRun || n == 0 {
+		return
+	}
+	droppedSamples.WithLabelValues(reason).Add(float64(n))
+}
+
+// sent records that samples were accepted by the receiver, as the wire
+// series.
+func (p *pipeline) sent(samples, wire []prompb.TimeSeries) {
+	if p.orderer != nil {
+		p.orderer.commit(samples)
+	}
+	if p.deltas != nil {
+		p.deltas.commit(samples)
+	}
+	if p.verifier != nil {
+		// The receiver stored the series as they were sent.
+		p.verifier.written(wire)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// histogramBuckets returns the cumulative count of each bucket of h by upper
+// bound.
+func histogramBuckets(h prometheus.Histogram) map[float64]uint64 {
+	var pb dto.Metric
+	if err := h.Write(&pb); err != nil {
+		panic(err)
+	}
+	buckets := map[float64]uint64{}
+	for _, b := range pb.Histogram.Bucket {
+		buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+	}
+	return buckets
+}
+
+func TestLabelsPerSeries(t *testing.T) {
+	defer func(prev bool) { emitTargetInfo = prev }(emitTargetInfo)
+	emitTargetInfo = false
+	defer setInstance("pusher-1")()
+
+	path := filepath.Join(t.TempDir(), "labels.json")
+	writeLabelsFile(t, path, `{"cluster": "eu-1", "region": "eu", "env": "prod"}`, time.Now())
+	external, err := newExternalLabelsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_labelled", Help: "test"}, []string{"a", "b"})
+	r.MustRegister(g)
+	g.WithLabelValues("x", "y").Set(1)
+	p := newPipeline(r, external)
+
+	before := histogramBuckets(labelsPerSeries)
+	if _, err := p.collect(false); err != nil {
+		t.Fatal(err)
+	}
+	after := histogramBuckets(labelsPerSeries)
+	// __name__, a and b, the 3 external labels and the instance label.
+	for le, want := range map[float64]uint64{4: 0, 8: 1, 16: 1} {
+		if got := after[le] - before[le]; got != want {
+			t.Errorf("bucket le=%v grew by %d, want %d", le, got, want)
+		}
+	}
+
+	before = after
+	if _, err := p.collect(true); err != nil {
+		t.Fatal(err)
+	}
+	if got := histogramBuckets(labelsPerSeries)[64] - before[64]; got != 0 {
+		t.Errorf("dry run observed %d series", got)
+	}
+}
+
+func TestInstanceLabelOnEverySeries(t *testing.T) {
+	defer setInstance("pusher-1")()
+	defer func(prev bool) { syntheticMetrics = prev }(syntheticMetrics)
+	syntheticMetrics = true
+
+	r := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_labelled", Help: "test"}, []string{"zone"})
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_latency", Help: "test"})
+	r.MustRegister(g, h)
+	g.WithLabelValues("a").Set(1)
+	h.Observe(1)
+
+	samples, err := newPipeline(r, nil).collect(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) == 0 {
+		t.Fatal("got no series")
+	}
+	for _, s := range samples {
+		if v := labelValue(s.Labels, instanceLabel); v != "pusher-1" {
+			t.Errorf("series %s has %s=%q", seriesKey(s.Labels), instanceLabel, v)
+		}
+		for i := 1; i < len(s.Labels); i++ {
+			if s.Labels[i-1].Name >= s.Labels[i].Name {
+				t.Errorf("labels of %s aren't sorted", seriesKey(s.Labels))
+				break
+			}
+		}
+	}
+}
+
+func TestInstanceLabelDisabled(t *testing.T) {
+	defer func(prev string) { instanceLabel = prev }(instanceLabel)
+	instanceLabel = ""
+
+	r := prometheus.NewRegistry()
+	r.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_plain", Help: "test"}))
+	samples, err := newPipeline(r, nil).collect(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range samples {
+		if v := labelValue(s.Labels, "remote_write_instance"); v != "" {
+			t.Errorf("series %s has the instance label", seriesKey(s.Labels))
+		}
+	}
+}
+
+func TestTimestampOffset(t *testing.T) {
+	defer func(prev time.Duration) { timestampOffset = prev }(timestampOffset)
+	defer func(prev bool) { syntheticMetrics = prev }(syntheticMetrics)
+	timestampOffset = -time.Hour
+	syntheticMetrics = true
+
+	r := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_offset", Help: "test"}, []string{"i"})
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_offset_seconds", Help: "test"})
+	r.MustRegister(g, h)
+	for i := 0; i < 10; i++ {
+		g.WithLabelValues(fmt.Sprint(i)).Set(float64(i))
+	}
+	h.Observe(1)
+
+	before := model.Now().Add(timestampOffset)
+	samples, err := newPipeline(r, nil).collect(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := model.Now().Add(timestampOffset)
+
+	ts := samples[0].Samples[0].Timestamp
+	if ts < int64(before) || ts > int64(after) {
+		t.Errorf("timestamp %d isn't offset into [%d, %d]", ts, before, after)
+	}
+	for _, s := range samples {
+		for _, sample := range s.Samples {
+			if sample.Timestamp != ts {
+				t.Errorf("series %s has timestamp %d, want %d like the rest of the batch", seriesKey(s.Labels), sample.Timestamp, ts)
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var (
+	writeRequestPool = sync.Pool{
+		New: func() interface{} {
+			return &prompb.WriteRequest{}
+		},
+	}
+
+	marshalBufferPool = sync.Pool{
+		New: func() interface{} {
+			return new([]byte)
+		},
+	}
+)
+
+// buildPooledWriteRequest does the same as buildWriteRequest, but reuses the
+// WriteRequest, the backing array of its series and the marshalling buffer
+// between calls. They're only used until the request is compressed, so
+// nothing of them outlives the call.
+func buildPooledWriteRequest(samples []prompb.TimeSeries) ([]byte, error) {
+	req := writeRequestPool.Get().(*prompb.WriteRequest)
+	defer putWriteRequest(req)
+	buf := marshalBufferPool.Get().(*[]byte)
+	defer marshalBufferPool.Put(buf)
+
+	req.Timeseries = append(req.Timeseries[:0], samples...)
+	size := req.Size()
+	if cap(*buf) < size {
+		*buf = make([]byte, size)
+	}
+	n, err := req.MarshalTo((*buf)[:size])
+	if err != nil {
+		return nil, err
+	}
+
+	return compress((*buf)[:n])
+}
+
+// putWriteRequest resets req and returns it to the pool. The series are
+// zeroed, so the pooled array keeps no labels or samples alive, and every
+// other field is reset by assigning a fresh request, so none added to
+// prompb.WriteRequest later can leak into the next request either. Only the
+// empty backing array is kept.
+func putWriteRequest(req *prompb.WriteRequest) {
+	for i := range req.Timeseries {
+		req.Timeseries[i] = prompb.TimeSeries{}
+	}
+	*req = prompb.WriteRequest{Timeseries: req.Timeseries[:0]}
+	writeRequestPool.Put(req)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func testSeries(n int, name string) []prompb.TimeSeries {
+	series := make([]prompb.TimeSeries, n)
+	for i := range series {
+		series[i] = prompb.TimeSeries{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: name},
+				{Name: "i", Value: fmt.Sprint(i)},
+			},
+			Samples: []prompb.Sample{{Value: float64(i), Timestamp: 1000}},
+		}
+	}
+	return series
+}
+
+func decodeWriteRequest(t testing.TB, data []byte) prompb.WriteRequest {
+	raw, err := snappy.Decode(nil, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req prompb.WriteRequest
+	if err := proto.Unmarshal(raw, &req); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestPooledWriteRequestNoStaleSeries(t *testing.T) {
+	large, err := buildPooledWriteRequest(testSeries(100, "large"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	small, err := buildPooledWriteRequest(testSeries(2, "small"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := decodeWriteRequest(t, large).Timeseries; len(got) != 100 {
+		t.Errorf("first request holds %d series, want 100", len(got))
+	}
+	got := decodeWriteRequest(t, small).Timeseries
+	if !reflect.DeepEqual(got, testSeries(2, "small")) {
+		t.Errorf("second request holds %v, want only its own 2 series", got)
+	}
+}
+
+func TestPutWriteRequestResets(t *testing.T) {
+	req := &prompb.WriteRequest{Timeseries: testSeries(3, "stale")}
+	putWriteRequest(req)
+	if len(req.Timeseries) != 0 {
+		t.Errorf("pooled request holds %d series", len(req.Timeseries))
+	}
+	for i, s := range req.Timeseries[:cap(req.Timeseries)] {
+		if s.Labels != nil || s.Samples != nil {
+			t.Errorf("pooled array still references series %d", i)
+		}
+	}
+}
+
+func TestPooledConsecutivePushes(t *testing.T) {
+	defer func(prev bool) { poolWriteRequests = prev }(poolWriteRequests)
+	poolWriteRequests = true
+
+	r := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_pooled", Help: "test"}, []string{"i"})
+	r.MustRegister(g)
+	for i := 0; i < 50; i++ {
+		g.WithLabelValues(fmt.Sprint(i)).Set(float64(i))
+	}
+	p := newPipeline(r, nil)
+	sink := NewMemorySink(10)
+	push := func() prompb.WriteRequest {
+		batches, err := nextBatches(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pending := sendBatches(context.Background(), sink, p, nil, batches, time.Now().Add(time.Minute), make(chan struct{})); len(pending) != 0 {
+			t.Fatalf("%d batches left pending", len(pending))
+		}
+		requests := sink.Drain()
+		if len(requests) != 1 {
+			t.Fatalf("got %d requests, want 1", len(requests))
+		}
+		return requests[0]
+	}
+
+	if got := seriesByName(push().Timeseries, "test_pooled"); len(got) != 50 {
+		t.Fatalf("first push sent %d series, want 50", len(got))
+	}
+	g.Reset()
+	g.WithLabelValues("only").Set(-1)
+	got := seriesByName(push().Timeseries, "test_pooled")
+	if len(got) != 1 || labelValue(got[0].Labels, "i") != "only" || got[0].Samples[0].Value != -1 {
+		t.Errorf("second push sent %v, want only the remaining series", got)
+	}
+}
+
+func TestPooledWriteRequestConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				want := testSeries(1+(g+i)%20, fmt.Sprintf("g%d", g))
+				data, err := buildPooledWriteRequest(want)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if got := decodeWriteRequest(t, data).Timeseries; !reflect.DeepEqual(got, want) {
+					t.Errorf("goroutine %d got %d series, want %d", g, len(got), len(want))
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func BenchmarkBuildWriteRequest(b *testing.B) {
+	series := testSeries(1000, "bench")
+	for _, pooled := range []bool{false, true} {
+		b.Run(fmt.Sprintf("pooled=%v", pooled), func(b *testing.B) {
+			defer func(prev bool) { poolWriteRequests = prev }(poolWriteRequests)
+			poolWriteRequests = pooled
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := buildWriteRequest(series); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
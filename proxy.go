@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// parseSOCKS5URL parses a SOCKS5 proxy address given as
+// [socks5://][user:pass@]host:port. net/http dials through SOCKS5 proxies
+// itself, including username/password authentication, so the result can be
+// used as a regular proxy URL.
+func parseSOCKS5URL(addr string) (*url.URL, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "socks5://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "socks5" {
+		return nil, fmt.Errorf("unsupported SOCKS5 proxy scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing SOCKS5 proxy host in %q", addr)
+	}
+	return u, nil
+}
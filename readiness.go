@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	config_util "github.com/prometheus/common/config"
+)
+
+// readinessProbe periodically sends an empty write request to the remote
+// write endpoint and caches whether it was accepted.
+type readinessProbe struct {
+	url     string
+	client  *http.Client
+	timeout time.Duration
+	// headers are set on every probe request, e.g. the default tenant.
+	headers map[string]string
+
+	mu     sync.RWMutex
+	ready  bool
+	reason string
+}
+
+func newReadinessProbe(url string, cfg config_util.HTTPClientConfig, timeout time.Duration) (*readinessProbe, error) {
+	client, err := config_util.NewClientFromConfig(cfg, "readiness_probe")
+	if err != nil {
+		return nil, err
+	}
+	return &readinessProbe{
+		url:     url,
+		client:  client,
+		timeout: timeout,
+		reason:  "not probed yet",
+	}, nil
+}
+
+// run probes the endpoint every interval until stopCh is closed.
+func (p *readinessProbe) run(interval time.Duration, stopCh chan struct{}) {
+	for {
+		p.probe()
+		select {
+		case <-time.After(interval):
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (p *readinessProbe) probe() {
+	ready, reason := p.check()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !ready && reason != p.reason {
+		log.Printf("remote write endpoint %s is not ready: %s", p.url, reason)
+	}
+	p.ready, p.reason = ready, reason
+}
+
+func (p *readinessProbe) check() (bool, string) {
+	body, err := buildWriteRequest(nil)
+	if err != nil {
+		return false, err.Error()
+	}
+	req, err := http.NewRequest("POST", p.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err.Error()
+	}
+	req.Header.Add("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for name, value := range p.headers {
+		req.Header.Set(name, value)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, fmt.Sprintf("endpoint unreachable: %v", err)
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return false, fmt.Sprintf("authentication/authorization failure: server returned HTTP status %s, check the credentials", resp.Status)
+	case resp.StatusCode == http.StatusNotFound || looksLikeHTML(resp):
+		return false, fmt.Sprintf("endpoint doesn't look like a remote-write receiver (HTTP status %s, Content-Type %q); check the path of the URL", resp.Status, resp.Header.Get("Content-Type"))
+	case resp.StatusCode/100 != 2:
+		return false, fmt.Sprintf("probe write rejected: server returned HTTP status %s", resp.Status)
+	}
+	return true, ""
+}
+
+// looksLikeHTML reports whether the response is a web page rather than a
+// remote write response, e.g. because the URL points at a UI.
+func looksLikeHTML(resp *http.Response) bool {
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return true
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	start := strings.ToLower(strings.TrimSpace(string(body)))
+	return strings.HasPrefix(start, "<!doctype html") || strings.HasPrefix(start, "<html")
+}
+
+func (p *readinessProbe) status() (bool, string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.ready, p.reason
+}
+
+func (p *readinessProbe) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ready, reason := p.status()
+	if pausedNotReady && pause.isPaused() {
+		ready, reason = false, "paused"
+	}
+	if !ready {
+		http.Error(w, "not ready: "+reason, http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
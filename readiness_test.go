@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	config_util "github.com/prometheus/common/config"
+)
+
+// probeReadiness probes a receiver served by h once and returns the /readyz
+// response.
+func probeReadiness(t *testing.T, h http.HandlerFunc, configure func(*readinessProbe)) *httptest.ResponseRecorder {
+	receiver := httptest.NewServer(h)
+	defer receiver.Close()
+	p, err := newReadinessProbe(receiver.URL, config_util.HTTPClientConfig{}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configure != nil {
+		configure(p)
+	}
+	p.probe()
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	return rec
+}
+
+func TestReadinessProbe(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		status int
+		code   int
+		reason string
+	}{
+		{"accepting", http.StatusNoContent, http.StatusOK, "ok"},
+		{"unauthorized", http.StatusUnauthorized, http.StatusServiceUnavailable, "authentication/authorization failure"},
+		{"forbidden", http.StatusForbidden, http.StatusServiceUnavailable, "authentication/authorization failure"},
+		{"failing", http.StatusInternalServerError, http.StatusServiceUnavailable, "probe write rejected"},
+	} {
+		rec := probeReadiness(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(c.status)
+		}, nil)
+		if rec.Code != c.code || !strings.Contains(rec.Body.String(), c.reason) {
+			t.Errorf("%s: got %d %q, want %d with %q", c.name, rec.Code, rec.Body, c.code, c.reason)
+		}
+	}
+}
+
+func TestReadinessProbeNotAReceiver(t *testing.T) {
+	for name, h := range map[string]http.HandlerFunc{
+		"html content type": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<p>Grafana</p>"))
+		},
+		"html body": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte("\n<!DOCTYPE html><html><body>Login</body></html>"))
+		},
+		"not found": func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		},
+	} {
+		rec := probeReadiness(t, h, nil)
+		if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "doesn't look like a remote-write receiver") {
+			t.Errorf("%s: got %d %q", name, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestReadinessProbeHeaders(t *testing.T) {
+	rec := probeReadiness(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Scope-OrgID") == "" {
+			http.Error(w, "no org id", http.StatusUnauthorized)
+		}
+	}, func(p *readinessProbe) {
+		p.headers = map[string]string{"X-Scope-OrgID": "default"}
+	})
+	if rec.Code != http.StatusOK {
+		t.Errorf("got %d %q, want the probe to carry the tenant header", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Policies for labels with reserved, double underscore prefixed names other
+// than __name__.
+const (
+	reservedLabelsOff    = "off"
+	reservedLabelsDrop   = "drop"
+	reservedLabelsRename = "rename"
+	reservedLabelsFail   = "fail"
+)
+
+var reservedLabels = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "remote_write_reserved_labels_total",
+	Help: "Labels with reserved names found in gathered series, by the action taken",
+}, []string{"action"})
+
+// isReservedLabel reports whether name is reserved for internal use. The
+// metric name and the tenant label, which is removed before sending, are
+// allowed.
+func isReservedLabel(name string) bool {
+	return strings.HasPrefix(name, "__") && name != "__name__" && name != tenantLabel
+}
+
+// checkReservedLabels applies policy to the reserved labels of series. With
+// the drop policy they're removed; with the rename policy the leading
+// underscores are stripped, unless that clashes with an existing label, in
+// which case they're removed too; with the fail policy an error is returned.
+// A dry run doesn't count the labels.
+func checkReservedLabels(series []prompb.TimeSeries, policy string, dryRun bool) ([]prompb.TimeSeries, error) {
+	if policy == reservedLabelsOff {
+		return series, nil
+	}
+	for i, s := range series {
+		var (
+			labels  = make([]prompb.Label, 0, len(s.Labels))
+			renamed bool
+		)
+		for _, l := range s.Labels {
+			if !isReservedLabel(l.Name) {
+				labels = append(labels, l)
+				continue
+			}
+			switch policy {
+			case reservedLabelsFail:
+				return nil, fmt.Errorf("series %s has reserved label %q", seriesKey(s.Labels), l.Name)
+			case reservedLabelsRename:
+				name := strings.TrimLeft(l.Name, "_")
+				if name != "" && !hasLabel(s.Labels, name) && !hasLabel(labels, name) {
+					labels = append(labels, prompb.Label{Name: name, Value: l.Value})
+					renamed = true
+					if !dryRun {
+						reservedLabels.WithLabelValues(reservedLabelsRename).Inc()
+					}
+					continue
+				}
+			}
+			if !dryRun {
+				reservedLabels.WithLabelValues(reservedLabelsDrop).Inc()
+			}
+		}
+		if renamed {
+			sortLabels(labels)
+		}
+		series[i].Labels = labels
+	}
+	return series, nil
+}
+
+func hasLabel(labels []prompb.Label, name string) bool {
+	for _, l := range labels {
+		if l.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func reservedSeries() []prompb.TimeSeries {
+	return []prompb.TimeSeries{
+		{Labels: []prompb.Label{{Name: "__foo", Value: "1"}, {Name: "__name__", Value: "a"}, {Name: "zone", Value: "x"}}},
+		// Renaming __bar would clash with bar.
+		{Labels: []prompb.Label{{Name: "__bar", Value: "2"}, {Name: "__name__", Value: "b"}, {Name: "bar", Value: "kept"}}},
+	}
+}
+
+func TestCheckReservedLabels(t *testing.T) {
+	for _, c := range []struct {
+		policy  string
+		want    []string
+		dropped float64
+		renamed float64
+	}{
+		{reservedLabelsOff, []string{
+			seriesKey(reservedSeries()[0].Labels),
+			seriesKey(reservedSeries()[1].Labels),
+		}, 0, 0},
+		{reservedLabelsDrop, []string{
+			seriesKey([]prompb.Label{{Name: "__name__", Value: "a"}, {Name: "zone", Value: "x"}}),
+			seriesKey([]prompb.Label{{Name: "__name__", Value: "b"}, {Name: "bar", Value: "kept"}}),
+		}, 2, 0},
+		{reservedLabelsRename, []string{
+			seriesKey([]prompb.Label{{Name: "__name__", Value: "a"}, {Name: "foo", Value: "1"}, {Name: "zone", Value: "x"}}),
+			seriesKey([]prompb.Label{{Name: "__name__", Value: "b"}, {Name: "bar", Value: "kept"}}),
+		}, 1, 1},
+	} {
+		dropped := metricValue(reservedLabels.WithLabelValues(reservedLabelsDrop))
+		renamed := metricValue(reservedLabels.WithLabelValues(reservedLabelsRename))
+		got, err := checkReservedLabels(reservedSeries(), c.policy, false)
+		if err != nil {
+			t.Fatalf("%s: %v", c.policy, err)
+		}
+		for i, s := range got {
+			if key := seriesKey(s.Labels); key != c.want[i] {
+				t.Errorf("%s: series %d is %q, want %q", c.policy, i, key, c.want[i])
+			}
+		}
+		if n := metricValue(reservedLabels.WithLabelValues(reservedLabelsDrop)) - dropped; n != c.dropped {
+			t.Errorf("%s: counted %v dropped labels, want %v", c.policy, n, c.dropped)
+		}
+		if n := metricValue(reservedLabels.WithLabelValues(reservedLabelsRename)) - renamed; n != c.renamed {
+			t.Errorf("%s: counted %v renamed labels, want %v", c.policy, n, c.renamed)
+		}
+	}
+
+	if _, err := checkReservedLabels(reservedSeries(), reservedLabelsFail, false); err == nil {
+		t.Error("fail: got no error")
+	}
+}
+
+func TestReservedTenantLabelAllowed(t *testing.T) {
+	defer func(prev string) { tenantLabel = prev }(tenantLabel)
+	tenantLabel = "__tenant__"
+
+	series := []prompb.TimeSeries{{Labels: []prompb.Label{{Name: "__name__", Value: "a"}, {Name: "__tenant__", Value: "t"}}}}
+	got, err := checkReservedLabels(series, reservedLabelsFail, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labelValue(got[0].Labels, "__tenant__") != "t" {
+		t.Error("tenant label was removed")
+	}
+}
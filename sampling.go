@@ -0,0 +1,36 @@
+package main
+
+import (
+	"math"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// sampleSeries keeps about fraction of the series. Whether a series is kept
+// only depends on its labels, so the same series are kept on every push.
+func sampleSeries(series []prompb.TimeSeries, fraction float64) []prompb.TimeSeries {
+	threshold := uint64(fraction * math.MaxUint64)
+	if fraction >= 1 {
+		threshold = math.MaxUint64
+	}
+
+	out := series[:0]
+	for _, s := range series {
+		if mixHash(seriesHash(s.Labels)) <= threshold {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// mixHash spreads the bits of h over the whole range. The high bits of FNV
+// hashes of label sets differing only in their last bytes are far from
+// uniform, which would skew the fraction kept.
+func mixHash(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
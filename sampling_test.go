@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestSampleSeries(t *testing.T) {
+	const n = 10000
+	kept := map[string]bool{}
+	for _, s := range sampleSeries(testSeries(n, "sampled"), 0.25) {
+		kept[seriesKey(s.Labels)] = true
+	}
+	if got := float64(len(kept)) / n; got < 0.22 || got > 0.28 {
+		t.Errorf("kept %v of the series, want about 0.25", got)
+	}
+
+	// The same series are kept on the next push, whatever the order.
+	series := testSeries(n, "sampled")
+	for i, j := 0, len(series)-1; i < j; i, j = i+1, j-1 {
+		series[i], series[j] = series[j], series[i]
+	}
+	again := sampleSeries(series, 0.25)
+	if len(again) != len(kept) {
+		t.Fatalf("kept %d series on the next push, want %d", len(again), len(kept))
+	}
+	for _, s := range again {
+		if !kept[seriesKey(s.Labels)] {
+			t.Fatalf("series %s wasn't kept before", seriesKey(s.Labels))
+		}
+	}
+
+	if got := len(sampleSeries(testSeries(100, "all"), 1)); got != 100 {
+		t.Errorf("fraction 1 kept %d of 100 series", got)
+	}
+}
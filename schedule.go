@@ -0,0 +1,115 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	nextPushTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "remote_write_next_push_timestamp_seconds",
+		Help: "Unix time at which the next push cycle is due",
+	})
+
+	effectivePushInterval = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "remote_write_effective_push_interval_seconds",
+		Help: "Time between two push cycles, as adapted by -adaptive-interval",
+	})
+
+	pushIntervalAdaptations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "remote_write_push_interval_adaptations_total",
+		Help: "Changes of the effective push interval, by direction",
+	}, []string{"direction"})
+)
+
+// backoffAfterCycles is how many push cycles in a row have to see
+// backpressure before the interval grows, so that a single 429 or slow push
+// doesn't slow down pushing.
+const backoffAfterCycles = 3
+
+// pushCadence is the effective push interval. With -adaptive-interval, it
+// doubles, up to -max-push-interval, after every cycle from the
+// backoffAfterCycles-th in a row in which the receiver answered 429 or a
+// push took more than half the interval, and shrinks back by a quarter
+// towards -push-interval after every cycle without either.
+type pushCadence struct {
+	mu           sync.Mutex
+	interval     time.Duration
+	backpressure bool
+	// strained counts the consecutive cycles that saw backpressure.
+	strained int
+}
+
+var cadence = &pushCadence{}
+
+func (c *pushCadence) current() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.currentLocked()
+}
+
+func (c *pushCadence) currentLocked() time.Duration {
+	if c.interval == 0 {
+		return pushInterval
+	}
+	return c.interval
+}
+
+// observe records the outcome of a push attempt that took d.
+func (c *pushCadence) observe(err error, d time.Duration) {
+	if !adaptiveInterval {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if isTooManyRequests(err) || d > c.currentLocked()/2 {
+		c.backpressure = true
+	}
+}
+
+// adapt updates the interval at the end of a push cycle.
+func (c *pushCadence) adapt() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old := c.currentLocked()
+	interval := old
+	if c.backpressure {
+		c.strained++
+	} else {
+		c.strained = 0
+	}
+	switch {
+	case c.strained >= backoffAfterCycles:
+		interval *= 2
+		if interval > maxPushInterval {
+			interval = maxPushInterval
+		}
+	case c.backpressure:
+		// Not strained for long enough yet, hold the interval.
+	case old > pushInterval:
+		interval -= interval / 4
+		if interval < pushInterval {
+			interval = pushInterval
+		}
+	}
+	c.backpressure = false
+	c.interval = interval
+	effectivePushInterval.Set(interval.Seconds())
+
+	switch {
+	case interval > old:
+		log.Printf("receiver is struggling, pushing every %s", interval)
+		pushIntervalAdaptations.WithLabelValues("up").Inc()
+	case interval < old:
+		log.Printf("receiver is recovering, pushing every %s", interval)
+		pushIntervalAdaptations.WithLabelValues("down").Inc()
+	}
+}
+
+// scheduleNextPush records that the next push cycle starts after wait.
+func scheduleNextPush(wait time.Duration) {
+	nextPushTimestamp.Set(float64(time.Now().Add(wait).UnixNano()) / 1e9)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	config_util "github.com/prometheus/common/config"
+)
+
+func TestNextPushTimestamp(t *testing.T) {
+	defer func(interval time.Duration, c *pushCadence) { pushInterval, cadence = interval, c }(pushInterval, cadence)
+	pushInterval = time.Hour
+	cadence = &pushCadence{}
+	nextPushTimestamp.Set(0)
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	start := time.Now()
+	go func() {
+		remoteWrite(newRecordingClient(), context.Background(), newPipeline(prometheus.NewRegistry(), nil), stopCh)
+		close(done)
+	}()
+	defer func() {
+		close(stopCh)
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for metricValue(nextPushTimestamp) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("next push timestamp wasn't set")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	next := metricValue(nextPushTimestamp)
+	earliest := float64(start.Add(pushInterval).UnixNano()) / 1e9
+	latest := float64(time.Now().Add(pushInterval).UnixNano()) / 1e9
+	if next < earliest || next > latest {
+		t.Errorf("next push at %v, want about one interval ahead, between %v and %v", next, earliest, latest)
+	}
+}
+
+func TestAdaptiveIntervalUnderTooManyRequests(t *testing.T) {
+	defer func(adaptive bool, interval, max time.Duration, c *pushCadence) {
+		adaptiveInterval, pushInterval, maxPushInterval, cadence = adaptive, interval, max, c
+	}(adaptiveInterval, pushInterval, maxPushInterval, cadence)
+	adaptiveInterval = true
+	pushInterval = time.Second
+	maxPushInterval = 8 * time.Second
+	cadence = &pushCadence{}
+
+	var limited int32 = 1
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&limited) == 1 {
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		}
+	}))
+	defer receiver.Close()
+	cl, err := newHTTPWriteClient(receiver.URL, config_util.HTTPClientConfig{}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cycle := func() time.Duration {
+		storeWithRetries(context.Background(), cl, nil, 0, time.Millisecond, time.Now().Add(time.Minute), make(chan struct{}))
+		cadence.adapt()
+		return cadence.current()
+	}
+
+	up := metricValue(pushIntervalAdaptations.WithLabelValues("up"))
+	down := metricValue(pushIntervalAdaptations.WithLabelValues("down"))
+	for _, want := range []time.Duration{time.Second, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second} {
+		if got := cycle(); got != want {
+			t.Errorf("after a 429: interval %s, want %s", got, want)
+		}
+		if got := metricValue(effectivePushInterval); got != want.Seconds() {
+			t.Errorf("effective interval gauge = %v, want %v", got, want.Seconds())
+		}
+	}
+
+	atomic.StoreInt32(&limited, 0)
+	for _, want := range []time.Duration{6 * time.Second, 4500 * time.Millisecond, 3375 * time.Millisecond} {
+		if got := cycle(); got != want {
+			t.Errorf("after a successful push: interval %s, want %s", got, want)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		cycle()
+	}
+	if got := cadence.current(); got != pushInterval {
+		t.Errorf("interval recovered to %s, want %s", got, pushInterval)
+	}
+
+	if got := metricValue(pushIntervalAdaptations.WithLabelValues("up")) - up; got != 3 {
+		t.Errorf("counted %v adaptations up, want 3", got)
+	}
+	if got := metricValue(pushIntervalAdaptations.WithLabelValues("down")) - down; got < 4 {
+		t.Errorf("counted %v adaptations down, want at least 4", got)
+	}
+}
+
+func TestAdaptiveIntervalOnSlowPushes(t *testing.T) {
+	defer func(adaptive bool, interval, max time.Duration) {
+		adaptiveInterval, pushInterval, maxPushInterval = adaptive, interval, max
+	}(adaptiveInterval, pushInterval, maxPushInterval)
+	adaptiveInterval = true
+	pushInterval = time.Second
+	maxPushInterval = 4 * time.Second
+
+	c := &pushCadence{}
+	for _, want := range []time.Duration{time.Second, time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		c.observe(nil, 3*time.Second)
+		c.adapt()
+		if got := c.current(); got != want {
+			t.Errorf("after a slow push: interval %s, want %s", got, want)
+		}
+		if got := metricValue(effectivePushInterval); got != want.Seconds() {
+			t.Errorf("effective interval gauge = %v, want %v", got, want.Seconds())
+		}
+	}
+}
+
+func TestAdaptiveIntervalIgnoresIsolatedBackpressure(t *testing.T) {
+	defer func(adaptive bool, interval time.Duration) {
+		adaptiveInterval, pushInterval = adaptive, interval
+	}(adaptiveInterval, pushInterval)
+	adaptiveInterval = true
+	pushInterval = time.Second
+
+	c := &pushCadence{}
+	up := metricValue(pushIntervalAdaptations.WithLabelValues("up"))
+	tooMany := statusError{code: http.StatusTooManyRequests, msg: "server returned HTTP status 429 Too Many Requests"}
+	// Never backoffAfterCycles strained cycles in a row.
+	for i, limited := range []bool{true, false, true, true, false, true, false, false, true, true} {
+		var err error
+		if limited {
+			err = tooMany
+		}
+		c.observe(err, time.Millisecond)
+		c.adapt()
+		if got := c.current(); got != pushInterval {
+			t.Fatalf("cycle %d: interval %s, want %s", i, got, pushInterval)
+		}
+	}
+	if got := metricValue(pushIntervalAdaptations.WithLabelValues("up")) - up; got != 0 {
+		t.Errorf("counted %v adaptations up, want none", got)
+	}
+}
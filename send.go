@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var (
+	batchAttempts = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "remote_write_batch_attempts",
+		Help:    "Number of attempts it took for a batch to be sent or given up on",
+		Buckets: []float64{1, 2, 3, 5, 8, 13},
+	})
+
+	batchOutcomes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "remote_write_batch_outcome_total",
+		Help: "Batches that reached a terminal state, by outcome",
+	}, []string{"outcome"})
+
+	pushDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "remote_write_request_duration_seconds",
+		Help:    "Duration of push attempts, by endpoint",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	pushDurationSummary = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Name:       "remote_write_duration_seconds",
+		Help:       "Duration of push attempts, by endpoint",
+		Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+	}, []string{"endpoint"})
+
+	pushTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "remote_write_timeouts_total",
+		Help: "Push attempts that exceeded -push-timeout or ran into the end of their push cycle",
+	})
+)
+
+// observePushDuration records a push attempt in the latency metrics enabled
+// by -push-latency-metrics.
+func observePushDuration(endpoint string, d time.Duration) {
+	if pushLatencyMetrics != "summary" {
+		pushDurationHistogram.WithLabelValues(endpoint).Observe(d.Seconds())
+	}
+	if pushLatencyMetrics != "histogram" {
+		pushDurationSummary.WithLabelValues(endpoint).Observe(d.Seconds())
+	}
+}
+
+// isTimeout reports whether err is a push that ran out of time.
+func isTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// isConnectionError reports whether err is a push that failed before the
+// request could reach the receiver: dialing failed, or the TLS handshake did,
+// be it on a certificate that doesn't verify, an alert from the server, or a
+// server not speaking TLS at all. Any other failure, including timeouts and
+// error responses, may have been partially applied by the receiver.
+func isConnectionError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "remote error") {
+		return true
+	}
+	var (
+		verifyErr    *tls.CertificateVerificationError
+		alertErr     tls.AlertError
+		recordErr    tls.RecordHeaderError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	// When a server answers in plain HTTP, the client returns a *url.Error
+	// wrapping http.ErrSchemeMismatch instead of the record header error.
+	// Checked against Go 1.21, where the error was exported, up to 1.27.
+	var urlErr *url.Error
+	schemeMismatch := errors.As(err, &urlErr) && errors.Is(urlErr.Err, http.ErrSchemeMismatch)
+	return errors.As(err, &verifyErr) ||
+		errors.As(err, &alertErr) ||
+		schemeMismatch ||
+		errors.As(err, &recordErr) ||
+		errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidErr)
+}
+
+// storeWithRetries sends req, retrying up to retries times with an
+// exponential backoff starting at backoff. Each attempt times out after
+// -push-timeout or at deadline, whichever comes first. No attempt is started
+// after deadline, and it gives up early if a retry wouldn't start before
+// deadline or if stopCh is closed.
+func storeWithRetries(ctx context.Context, cl writeClient, req []byte, retries int, backoff time.Duration, deadline time.Time, stopCh chan struct{}) error {
+	attempts := 0
+	var err error
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			if err == nil {
+				err = fmt.Errorf("push cycle ended before the request was sent: %w", context.DeadlineExceeded)
+			}
+			break
+		}
+		timeout := pushTimeout
+		if remaining < timeout {
+			timeout = remaining
+		}
+
+		attempts++
+		start := time.Now()
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		err = cl.Store(attemptCtx, req)
+		cancel()
+		d := time.Since(start)
+		observePushDuration(cl.Name(), d)
+		cadence.observe(err, d)
+		if err != nil && isTimeout(err) {
+			pushTimeouts.Inc()
+		}
+		if err == nil || attempts > retries {
+			break
+		}
+		if retryOnlyOnConnectionErrors && !isConnectionError(err) {
+			log.Printf("push attempt %d failed, not retrying as the request may have reached the receiver: %v", attempts, err)
+			break
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			log.Printf("push attempt %d failed, not retrying past the next push cycle: %v", attempts, err)
+			break
+		}
+		log.Printf("push attempt %d failed, retrying in %s: %v", attempts, backoff, err)
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+			continue
+		case <-stopCh:
+		}
+		break
+	}
+
+	batchAttempts.Observe(float64(attempts))
+	if err != nil {
+		batchOutcomes.WithLabelValues("dropped").Inc()
+		return err
+	}
+	batchOutcomes.WithLabelValues("success").Inc()
+	return nil
+}
+
+// batch is a write request ready to be sent, along with the series it holds,
+// the tenant it belongs to and the headers to send it with.
+type batch struct {
+	samples []prompb.TimeSeries
+	// wire holds the series as they were encoded, which can differ from
+	// samples in the stripped tenant label and truncated label values.
+	wire    []prompb.TimeSeries
+	data    []byte
+	tenant  string
+	headers map[string]string
+}
+
+// nextBatches gathers and converts the metrics into a batch, or one batch per
+// tenant with -tenant-label. A batch that can't be built is dropped, the
+// others are still returned.
+func nextBatches(p *pipeline) ([]batch, error) {
+	samples, err := p.collect(false)
+	if err != nil {
+		return nil, err
+	}
+	groups := []tenantSeries{{series: samples}}
+	if tenantLabel != "" {
+		groups = splitByTenant(samples, tenantLabel, defaultTenant)
+	}
+
+	var (
+		batches []batch
+		errs    []string
+	)
+	for _, t := range groups {
+		b, err := newBatch(t.series, t.tenant)
+		if err != nil {
+			droppedSamples.WithLabelValues(dropReasonEncode).Add(float64(countSamples(t.series)))
+			errs = append(errs, err.Error())
+			continue
+		}
+		batches = append(batches, b)
+	}
+	if len(errs) > 0 {
+		return batches, fmt.Errorf("dropping %d of %d batches: %s", len(errs), len(groups), strings.Join(errs, "; "))
+	}
+	return batches, nil
+}
+
+// newBatch builds the write request for samples. Tenant labels starting with
+// "__" are internal and not sent. Labels changed on the wire are changed on
+// copies, so samples keep the labels they were collected with.
+func newBatch(samples []prompb.TimeSeries, tenant string) (batch, error) {
+	wire := samples
+	switch {
+	case tenant != "" && strings.HasPrefix(tenantLabel, "__"):
+		wire = withoutLabel(samples, tenantLabel)
+	case wireMaxLabelValueBytes > 0:
+		wire = withoutLabel(samples, "")
+	}
+	if wireMaxLabelValueBytes > 0 {
+		truncatedLabelValues.Add(float64(truncateLabelValues(wire, wireMaxLabelValueBytes, wireTruncationMarker)))
+	}
+	data, err := buildWriteRequest(wire)
+	if err != nil {
+		return batch{}, err
+	}
+	observeCompressionRatio((&prompb.WriteRequest{Timeseries: wire}).Size(), len(data))
+	headers := sequence.headers(data)
+	if tenant != "" && tenantHeader != "" {
+		headers[tenantHeader] = tenant
+	}
+	return batch{samples: samples, wire: wire, data: data, tenant: tenant, headers: headers}, nil
+}
+
+// sendBatches sends the pending batches in order. Once the budget is
+// exhausted or deadline has passed, the remaining batches are returned to be
+// sent in a later interval. A nil budget sends everything. Retries aren't
+// started after deadline. Sent batches are written to -tee-file once.
+func sendBatches(ctx context.Context, cl writeClient, p *pipeline, budget *byteBudget, pending []batch, deadline time.Time, stopCh chan struct{}) []batch {
+	for len(pending) > 0 {
+		if !time.Now().Before(deadline) {
+			log.Printf("push cycle is over, deferring %d batches to the next interval", len(pending))
+			break
+		}
+		b := pending[0]
+		if budget != nil && !budget.take(len(b.data)) {
+			log.Printf("byte budget exhausted, deferring %d batches to the next interval", len(pending))
+			break
+		}
+		pending = pending[1:]
+
+		err := storeWithRetries(withTenant(withRequestHeaders(ctx, b.headers), b.tenant), cl, b.data, pushRetries, pushRetryBackoff, deadline, stopCh)
+		health.pushed(cl.Name(), err)
+		if err != nil {
+			log.Println(err)
+			droppedSamples.WithLabelValues(dropReasonSendFailed).Add(float64(countSamples(b.samples)))
+			continue
+		}
+		bytesSent.Add(float64(len(b.data)))
+		tee.write(b.data)
+		p.sent(b.samples, b.wire)
+
+		fmt.Println("pushed data....")
+	}
+	return pending
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	config_util "github.com/prometheus/common/config"
+)
+
+// flakyClient fails the first failures calls to Store with err.
+type flakyClient struct {
+	failures int
+	err      error
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (c *flakyClient) Store(ctx context.Context, req []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if c.calls <= c.failures {
+		return c.err
+	}
+	return nil
+}
+
+func (c *flakyClient) Name() string { return "flaky" }
+
+func histogramState() (count uint64, sum float64) {
+	var pb dto.Metric
+	if err := batchAttempts.Write(&pb); err != nil {
+		panic(err)
+	}
+	return pb.Histogram.GetSampleCount(), pb.Histogram.GetSampleSum()
+}
+
+func TestStoreWithRetriesThenSuccess(t *testing.T) {
+	cl := &flakyClient{failures: 2, err: errors.New("unavailable")}
+
+	count, sum := histogramState()
+	success := metricValue(batchOutcomes.WithLabelValues("success"))
+	dropped := metricValue(batchOutcomes.WithLabelValues("dropped"))
+
+	err := storeWithRetries(context.Background(), cl, nil, 3, time.Millisecond, time.Now().Add(time.Minute), make(chan struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl.calls != 3 {
+		t.Errorf("got %d attempts, want 3", cl.calls)
+	}
+	newCount, newSum := histogramState()
+	if newCount-count != 1 || newSum-sum != 3 {
+		t.Errorf("attempts histogram observed %d batches summing %v attempts, want 1 batch of 3", newCount-count, newSum-sum)
+	}
+	if got := metricValue(batchOutcomes.WithLabelValues("success")) - success; got != 1 {
+		t.Errorf("counted %v successes, want 1", got)
+	}
+	if got := metricValue(batchOutcomes.WithLabelValues("dropped")) - dropped; got != 0 {
+		t.Errorf("counted %v drops, want 0", got)
+	}
+}
+
+func TestStoreWithRetriesExhausted(t *testing.T) {
+	cl := &flakyClient{failures: 10, err: errors.New("unavailable")}
+	dropped := metricValue(batchOutcomes.WithLabelValues("dropped"))
+
+	err := storeWithRetries(context.Background(), cl, nil, 2, time.Millisecond, time.Now().Add(time.Minute), make(chan struct{}))
+	if err == nil {
+		t.Fatal("got no error")
+	}
+	if cl.calls != 3 {
+		t.Errorf("got %d attempts, want 3", cl.calls)
+	}
+	if got := metricValue(batchOutcomes.WithLabelValues("dropped")) - dropped; got != 1 {
+		t.Errorf("counted %v drops, want 1", got)
+	}
+}
+
+func TestStoreTimeout(t *testing.T) {
+	var calls int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		// The connection is only watched for the client going away once
+		// the body was read.
+		ioutil.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer receiver.Close()
+	cl, err := newHTTPWriteClient(receiver.URL, config_util.HTTPClientConfig{}, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timeouts := metricValue(pushTimeouts)
+	success := metricValue(batchOutcomes.WithLabelValues("success"))
+	err = storeWithRetries(context.Background(), cl, []byte("slow"), 1, time.Millisecond, time.Now().Add(time.Minute), make(chan struct{}))
+	if !isTimeout(err) {
+		t.Fatalf("got error %v, want a timeout", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("receiver got %d attempts, want the timeout retried once", got)
+	}
+	if got := metricValue(pushTimeouts) - timeouts; got != 2 {
+		t.Errorf("counted %v timeouts, want 2", got)
+	}
+	if got := metricValue(batchOutcomes.WithLabelValues("success")) - success; got != 0 {
+		t.Errorf("counted %v successes for a timed out batch", got)
+	}
+}
+
+// blockingClient blocks every Store until its context is done.
+type blockingClient struct{}
+
+func (blockingClient) Store(ctx context.Context, req []byte) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (blockingClient) Name() string { return "blocking" }
+
+func TestStoreWithRetriesStopsAtDeadline(t *testing.T) {
+	cl := &flakyClient{failures: 100, err: errors.New("unavailable")}
+	start := time.Now()
+	deadline := start.Add(100 * time.Millisecond)
+	if err := storeWithRetries(context.Background(), cl, nil, 100, 20*time.Millisecond, deadline, make(chan struct{})); err == nil {
+		t.Fatal("got no error")
+	}
+	if now := time.Now(); now.After(deadline) {
+		t.Errorf("retries ran %s past the deadline", now.Sub(deadline))
+	}
+	// Attempts at 0, 20, 60ms at best; the next one would start at 140ms.
+	if cl.calls > 3 {
+		t.Errorf("got %d attempts, want at most 3", cl.calls)
+	}
+
+	// A hanging attempt is cut off at the deadline.
+	deadline = time.Now().Add(50 * time.Millisecond)
+	err := storeWithRetries(context.Background(), blockingClient{}, nil, 100, time.Millisecond, deadline, make(chan struct{}))
+	if !isTimeout(err) {
+		t.Fatalf("got error %v, want a timeout", err)
+	}
+	if late := time.Since(deadline); late > time.Second {
+		t.Errorf("attempt ran %s past the deadline", late)
+	}
+}
+
+// latencyState returns the sample count and sum of the push latency summary
+// and histogram of endpoint.
+func latencyState(endpoint string) (summaryCount uint64, summarySum float64, histogramCount uint64) {
+	var s, h dto.Metric
+	if err := pushDurationSummary.WithLabelValues(endpoint).(prometheus.Metric).Write(&s); err != nil {
+		panic(err)
+	}
+	if err := pushDurationHistogram.WithLabelValues(endpoint).(prometheus.Metric).Write(&h); err != nil {
+		panic(err)
+	}
+	return s.Summary.GetSampleCount(), s.Summary.GetSampleSum(), h.Histogram.GetSampleCount()
+}
+
+func TestPushLatencySummary(t *testing.T) {
+	defer func(prev string) { pushLatencyMetrics = prev }(pushLatencyMetrics)
+
+	for _, c := range []struct {
+		mode               string
+		summary, histogram uint64
+	}{
+		{"summary", 3, 0},
+		{"both", 3, 3},
+		{"histogram", 0, 3},
+	} {
+		pushLatencyMetrics = c.mode
+		endpoint := "latency-" + c.mode
+		count, sum, histogramCount := latencyState(endpoint)
+		for _, d := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 700 * time.Millisecond} {
+			observePushDuration(endpoint, d)
+		}
+		newCount, newSum, newHistogramCount := latencyState(endpoint)
+		if newCount-count != c.summary || newHistogramCount-histogramCount != c.histogram {
+			t.Errorf("%s: summary observed %d and histogram %d durations, want %d and %d", c.mode, newCount-count, newHistogramCount-histogramCount, c.summary, c.histogram)
+		}
+		if c.summary > 0 && math.Abs(newSum-sum-1) > 1e-9 {
+			t.Errorf("%s: summary sum grew by %v, want 1", c.mode, newSum-sum)
+		}
+	}
+}
+
+func TestRetryOnlyOnConnectionErrors(t *testing.T) {
+	defer func(prev bool) { retryOnlyOnConnectionErrors = prev }(retryOnlyOnConnectionErrors)
+	retryOnlyOnConnectionErrors = true
+
+	var calls int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "partially applied", http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+	cl, err := newHTTPWriteClient(receiver.URL, config_util.HTTPClientConfig{}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storeWithRetries(context.Background(), cl, nil, 3, time.Millisecond, time.Now().Add(time.Minute), make(chan struct{})); err == nil {
+		t.Fatal("got no error")
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("receiver got %d attempts, want a 500 not to be retried", got)
+	}
+
+	// Nothing listens on the port of a closed server, so dialing fails.
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	cl, err = newHTTPWriteClient(closed.URL, config_util.HTTPClientConfig{}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, sum := histogramState()
+	if err := storeWithRetries(context.Background(), cl, nil, 3, time.Millisecond, time.Now().Add(time.Minute), make(chan struct{})); !isConnectionError(err) {
+		t.Fatalf("got error %v, want a connection error", err)
+	}
+	if newCount, newSum := histogramState(); newCount-count != 1 || newSum-sum != 4 {
+		t.Errorf("attempts histogram observed %d batches summing %v attempts, want 1 batch of 4", newCount-count, newSum-sum)
+	}
+}
+
+func TestIsConnectionErrorTLS(t *testing.T) {
+	// A TLS server with a certificate the client doesn't trust.
+	untrusted := httptest.NewTLSServer(http.NotFoundHandler())
+	defer untrusted.Close()
+	// A plain HTTP server the client speaks TLS to.
+	plain := httptest.NewServer(http.NotFoundHandler())
+	defer plain.Close()
+
+	for name, url := range map[string]string{
+		"unknown authority": untrusted.URL,
+		"not TLS":           "https" + strings.TrimPrefix(plain.URL, "http"),
+	} {
+		cl, err := newHTTPWriteClient(url, config_util.HTTPClientConfig{}, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cl.Store(context.Background(), nil); !isConnectionError(err) {
+			t.Errorf("%s: %v isn't a connection error", name, err)
+		}
+	}
+
+	for _, err := range []error{
+		statusError{code: http.StatusInternalServerError, msg: "server returned HTTP status 500"},
+		context.DeadlineExceeded,
+		errors.New("unexpected EOF"),
+		// Only the error of the client is trusted, not a look-alike message.
+		errors.New("http: server gave HTTP response to HTTPS client"),
+	} {
+		if isConnectionError(err) {
+			t.Errorf("%v is a connection error", err)
+		}
+	}
+}
+
+func TestStoreWithRetriesPushTimeout(t *testing.T) {
+	defer func(prev time.Duration) { pushTimeout = prev }(pushTimeout)
+	pushTimeout = 20 * time.Millisecond
+
+	// The push timeout applies even with the end of the cycle far away.
+	start := time.Now()
+	err := storeWithRetries(context.Background(), blockingClient{}, nil, 0, time.Millisecond, start.Add(time.Minute), make(chan struct{}))
+	if !isTimeout(err) {
+		t.Fatalf("got error %v, want a timeout", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("attempt took %s, want it cut off after -push-timeout", d)
+	}
+
+	// No attempt is started once the cycle is over.
+	cl := &flakyClient{}
+	if err := storeWithRetries(context.Background(), cl, nil, 3, time.Millisecond, time.Now(), make(chan struct{})); !isTimeout(err) {
+		t.Errorf("got error %v, want a timeout", err)
+	}
+	if cl.calls != 0 {
+		t.Errorf("got %d attempts after the deadline", cl.calls)
+	}
+}
+
+func TestSendBatchesDefersAfterDeadline(t *testing.T) {
+	p := newPipeline(prometheus.NewRegistry(), nil)
+	var batches []batch
+	for i := 0; i < 3; i++ {
+		b, err := newBatch(testSeries(1, "deadline"), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		batches = append(batches, b)
+	}
+
+	// The first batch hangs until the end of the cycle, the others wait for
+	// the next one instead of failing on an expired deadline.
+	timeouts := metricValue(pushTimeouts)
+	failed := metricValue(droppedSamples.WithLabelValues(dropReasonSendFailed))
+	pending := sendBatches(context.Background(), blockingClient{}, p, nil, batches, time.Now().Add(50*time.Millisecond), make(chan struct{}))
+	if len(pending) != 2 {
+		t.Fatalf("got %d pending batches, want 2", len(pending))
+	}
+	if got := metricValue(pushTimeouts) - timeouts; got != 1 {
+		t.Errorf("counted %v timeouts, want 1", got)
+	}
+	if got := metricValue(droppedSamples.WithLabelValues(dropReasonSendFailed)) - failed; got != 1 {
+		t.Errorf("counted %v samples failing to send, want 1", got)
+	}
+
+	cl := &flakyClient{}
+	if pending := sendBatches(context.Background(), cl, p, nil, batches, time.Now().Add(-time.Second), make(chan struct{})); len(pending) != len(batches) {
+		t.Errorf("got %d pending batches after the deadline, want %d", len(pending), len(batches))
+	}
+	if cl.calls != 0 {
+		t.Errorf("got %d pushes after the deadline", cl.calls)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// batchSequence numbers batches, so a receiver can spot missing ones. The
+// numbers start over when the process restarts, which shows in the start
+// time sent along with them.
+type batchSequence struct {
+	mu   sync.Mutex
+	next uint64
+}
+
+var sequence = &batchSequence{}
+
+// headers returns the sequence and checksum headers for a new batch, for the
+// header names that are set.
+func (s *batchSequence) headers(data []byte) map[string]string {
+	headers := map[string]string{}
+	if sequenceHeader != "" {
+		s.mu.Lock()
+		s.next++
+		headers[sequenceHeader] = fmt.Sprintf("%d-%d", startTime.Unix(), s.next)
+		s.mu.Unlock()
+	}
+	if checksumHeader != "" {
+		sum := sha256.Sum256(data)
+		headers[checksumHeader] = hex.EncodeToString(sum[:])
+	}
+	return headers
+}
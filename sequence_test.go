@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	config_util "github.com/prometheus/common/config"
+)
+
+func TestSequenceHeader(t *testing.T) {
+	defer func(seq, sum string, retries int, backoff time.Duration) {
+		sequenceHeader, checksumHeader, pushRetries, pushRetryBackoff = seq, sum, retries, backoff
+	}(sequenceHeader, checksumHeader, pushRetries, pushRetryBackoff)
+	sequenceHeader = "X-Batch-Sequence"
+	checksumHeader = "X-Batch-Checksum"
+	pushRetries = 2
+	pushRetryBackoff = time.Millisecond
+
+	var (
+		mu        sync.Mutex
+		sequences []string
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		if got := r.Header.Get(checksumHeader); got != hex.EncodeToString(sum[:]) {
+			t.Errorf("got checksum %q for a body hashing to %x", got, sum)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		sequences = append(sequences, r.Header.Get(sequenceHeader))
+		// The first attempt of every batch fails.
+		if len(sequences)%2 == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+	cl, err := newHTTPWriteClient(receiver.URL, config_util.HTTPClientConfig{}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var batches []batch
+	for i := 0; i < 2; i++ {
+		b, err := newBatch(testSeries(i+1, "sequenced"), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		batches = append(batches, b)
+	}
+	sendBatches(context.Background(), cl, newPipeline(prometheus.NewRegistry(), nil), nil, batches, time.Now().Add(time.Minute), make(chan struct{}))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sequences) != 4 {
+		t.Fatalf("got %d requests, want 2 attempts for each of 2 batches", len(sequences))
+	}
+	var first uint64
+	if _, err := fmt.Sscanf(sequences[0], fmt.Sprintf("%d-%%d", startTime.Unix()), &first); err != nil {
+		t.Fatalf("malformed sequence %q: %v", sequences[0], err)
+	}
+	want := []string{
+		fmt.Sprintf("%d-%d", startTime.Unix(), first),
+		fmt.Sprintf("%d-%d", startTime.Unix(), first),
+		fmt.Sprintf("%d-%d", startTime.Unix(), first+1),
+		fmt.Sprintf("%d-%d", startTime.Unix(), first+1),
+	}
+	for i := range want {
+		if sequences[i] != want[i] {
+			t.Errorf("got sequences %v, want %v", sequences, want)
+			break
+		}
+	}
+}
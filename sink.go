@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// writeClient sends encoded write requests. *remote.Client implements it.
+type writeClient interface {
+	Store(ctx context.Context, req []byte) error
+	Name() string
+}
+
+// MemorySink is a writeClient keeping the last received write requests in
+// memory instead of sending them anywhere, so the whole gather and send path
+// can be checked without a network.
+type MemorySink struct {
+	capacity int
+
+	mu       sync.Mutex
+	requests []prompb.WriteRequest
+}
+
+// NewMemorySink returns a MemorySink holding at most capacity requests,
+// dropping the oldest ones first. capacity must be at least 1.
+func NewMemorySink(capacity int) *MemorySink {
+	return &MemorySink{capacity: capacity}
+}
+
+// Store decodes and keeps req.
+func (s *MemorySink) Store(_ context.Context, req []byte) error {
+	data, err := snappy.Decode(nil, req)
+	if err != nil {
+		compressionErrors.WithLabelValues("decode", "snappy").Inc()
+		return err
+	}
+	var wr prompb.WriteRequest
+	if err := proto.Unmarshal(data, &wr); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, wr)
+	if len(s.requests) > s.capacity {
+		s.requests = s.requests[len(s.requests)-s.capacity:]
+	}
+	return nil
+}
+
+// Name identifies the sink.
+func (s *MemorySink) Name() string {
+	return "memory"
+}
+
+// Requests returns the kept requests, oldest first, without removing them.
+func (s *MemorySink) Requests() []prompb.WriteRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]prompb.WriteRequest(nil), s.requests...)
+}
+
+// Drain returns the kept requests, oldest first, and removes them.
+func (s *MemorySink) Drain() []prompb.WriteRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
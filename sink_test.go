@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestMemorySinkGatherSendCycle(t *testing.T) {
+	defer setInstance("test-instance")()
+
+	r := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "test_temperature",
+		Help: "test",
+	}, []string{"room"})
+	r.MustRegister(g)
+	g.WithLabelValues("kitchen").Set(21.5)
+	g.WithLabelValues("cellar").Set(12)
+
+	sink := NewMemorySink(10)
+	p := newPipeline(r, nil)
+	batches, err := nextBatches(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := sendBatches(context.Background(), sink, p, nil, batches, time.Now().Add(time.Minute), make(chan struct{}))
+	if len(pending) != 0 {
+		t.Fatalf("%d batches left pending", len(pending))
+	}
+
+	requests := sink.Drain()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	got := map[string]float64{}
+	for _, ts := range requests[0].Timeseries {
+		if labelValue(ts.Labels, "__name__") != "test_temperature" {
+			continue
+		}
+		if v := labelValue(ts.Labels, instanceLabel); v != "test-instance" {
+			t.Errorf("series %s has %s=%q", seriesKey(ts.Labels), instanceLabel, v)
+		}
+		got[labelValue(ts.Labels, "room")] = ts.Samples[0].Value
+	}
+	want := map[string]float64{"kitchen": 21.5, "cellar": 12}
+	if len(got) != len(want) {
+		t.Fatalf("got series %v, want %v", got, want)
+	}
+	for room, v := range want {
+		if got[room] != v {
+			t.Errorf("room %s: got %v, want %v", room, got[room], v)
+		}
+	}
+
+	if n := len(sink.Drain()); n != 0 {
+		t.Errorf("sink still holds %d requests after Drain", n)
+	}
+}
+
+func TestMemorySinkCapacity(t *testing.T) {
+	sink := NewMemorySink(2)
+	for i := 0; i < 3; i++ {
+		data, err := buildWriteRequest([]prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "m"}},
+			Samples: []prompb.Sample{{Value: float64(i)}},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Store(context.Background(), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	requests := sink.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(requests))
+	}
+	for i, req := range requests {
+		if v := req.Timeseries[0].Samples[0].Value; v != float64(i+1) {
+			t.Errorf("request %d holds %v, want %v", i, v, i+1)
+		}
+	}
+}
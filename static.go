@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// staleNaN is the value Prometheus uses to mark a series as stale.
+var staleNaN = math.Float64frombits(0x7ff0000000000002)
+
+// staticSeriesFilter stops sending series whose value didn't change for a
+// while. The sample crossing the limit is still sent, optionally followed by
+// a staleness marker on the next push. A series is sent again as soon as its
+// value changes.
+type staticSeriesFilter struct {
+	after       time.Duration
+	staleMarker bool
+
+	mu     sync.Mutex
+	series map[string]staticSeries
+}
+
+type staticSeries struct {
+	value      float64
+	changed    time.Time
+	dropped    bool
+	markerSent bool
+}
+
+func newStaticSeriesFilter(after time.Duration, staleMarker bool) *staticSeriesFilter {
+	return &staticSeriesFilter{
+		after:       after,
+		staleMarker: staleMarker,
+		series:      map[string]staticSeries{},
+	}
+}
+
+// apply filters out static series. Unless update is set, the tracked state
+// is left untouched.
+func (f *staticSeriesFilter) apply(series []prompb.TimeSeries, update bool) []prompb.TimeSeries {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	seen := make(map[string]staticSeries, len(f.series))
+	out := series[:0]
+	for _, s := range series {
+		if len(s.Samples) == 0 {
+			continue
+		}
+		key := seriesKey(s.Labels)
+		v := s.Samples[len(s.Samples)-1].Value
+		st, ok := f.series[key]
+
+		switch {
+		case !ok || !sameValue(st.value, v):
+			st = staticSeries{value: v, changed: now}
+			out = append(out, s)
+		case !st.dropped:
+			if now.Sub(st.changed) >= f.after {
+				st.dropped = true
+			}
+			out = append(out, s)
+		case f.staleMarker && !st.markerSent:
+			st.markerSent = true
+			last := s.Samples[len(s.Samples)-1]
+			last.Value = staleNaN
+			s.Samples = []prompb.Sample{last}
+			out = append(out, s)
+		}
+		seen[key] = st
+	}
+
+	if update {
+		f.series = seen
+	}
+	return out
+}
+
+func sameValue(a, b float64) bool {
+	return a == b || (math.IsNaN(a) && math.IsNaN(b))
+}
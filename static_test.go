@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// staticPush sends the static series with value static and the changing one
+// with value changing through f, returning the values sent by name.
+func staticPush(f *staticSeriesFilter, static, changing float64) map[string][]float64 {
+	series := []prompb.TimeSeries{
+		{Labels: []prompb.Label{{Name: "__name__", Value: "static"}}, Samples: []prompb.Sample{{Value: static, Timestamp: 1000}}},
+		{Labels: []prompb.Label{{Name: "__name__", Value: "changing"}}, Samples: []prompb.Sample{{Value: changing, Timestamp: 1000}}},
+	}
+	sent := map[string][]float64{}
+	for _, s := range f.apply(series, true) {
+		name := labelValue(s.Labels, "__name__")
+		for _, sample := range s.Samples {
+			sent[name] = append(sent[name], sample.Value)
+		}
+	}
+	return sent
+}
+
+// age makes every tracked series look unchanged for longer than f.after.
+func age(f *staticSeriesFilter) {
+	for key, st := range f.series {
+		st.changed = st.changed.Add(-2 * f.after)
+		f.series[key] = st
+	}
+}
+
+func TestStaticSeriesFilter(t *testing.T) {
+	for _, marker := range []bool{false, true} {
+		f := newStaticSeriesFilter(time.Hour, marker)
+		staticPush(f, 1, 1)
+		age(f)
+
+		// The sample crossing the limit is still sent.
+		sent := staticPush(f, 1, 2)
+		if len(sent["static"]) != 1 || len(sent["changing"]) != 1 {
+			t.Fatalf("marker=%v: crossing push sent %v", marker, sent)
+		}
+
+		sent = staticPush(f, 1, 3)
+		if marker {
+			if v := sent["static"]; len(v) != 1 || math.Float64bits(v[0]) != math.Float64bits(staleNaN) {
+				t.Errorf("marker=%v: got %v, want a staleness marker", marker, v)
+			}
+			sent = staticPush(f, 1, 4)
+		}
+		if v, ok := sent["static"]; ok {
+			t.Errorf("marker=%v: static series still sent: %v", marker, v)
+		}
+		if v := sent["changing"]; len(v) != 1 {
+			t.Errorf("marker=%v: changing series not sent", marker)
+		}
+
+		// A change brings the series back right away.
+		if v := staticPush(f, 2, 5)["static"]; len(v) != 1 || v[0] != 2 {
+			t.Errorf("marker=%v: changed static series sent as %v, want [2]", marker, v)
+		}
+	}
+}
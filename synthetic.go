@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"runtime"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// syntheticSeries builds a single-sample series the same way Prometheus
+// reports scrape statistics alongside scraped data.
+func syntheticSeries(name string, value float64, ts model.Time) prompb.TimeSeries {
+	return prompb.TimeSeries{
+		Labels: []prompb.Label{
+			{Name: model.MetricNameLabel, Value: name},
+		},
+		Samples: []prompb.Sample{
+			{Value: value, Timestamp: int64(ts)},
+		},
+	}
+}
+
+// scrapeSampleStats returns scrape_samples_scraped and
+// scrape_samples_post_metric_relabeling, counting the series before and after
+// they were filtered and transformed.
+func scrapeSampleStats(scraped, post int, now model.Time) []prompb.TimeSeries {
+	return []prompb.TimeSeries{
+		syntheticSeries("scrape_samples_scraped", float64(scraped), now),
+		syntheticSeries("scrape_samples_post_metric_relabeling", float64(post), now),
+	}
+}
+
+// targetInfo returns a series describing the host and build of this binary.
+// Its labels don't change while the process runs.
+func targetInfo(ts model.Time) prompb.TimeSeries {
+	hostname, _ := os.Hostname()
+	s := syntheticSeries("target_info", 1, ts)
+	s.Labels = append(s.Labels,
+		prompb.Label{Name: "arch", Value: runtime.GOARCH},
+		prompb.Label{Name: "go_version", Value: runtime.Version()},
+		prompb.Label{Name: "host", Value: hostname},
+		prompb.Label{Name: "os", Value: runtime.GOOS},
+		prompb.Label{Name: "version", Value: binaryVersion},
+	)
+	sortLabels(s.Labels)
+	return s
+}
+
+// externalLabelsInfo returns a series carrying the external labels, so they
+// can be joined on even where a series' own labels took precedence.
+func externalLabelsInfo(labels map[string]string, ts model.Time) prompb.TimeSeries {
+	name := "external_labels_info"
+	if internalMetricsPrefix != "" {
+		name = internalMetricsPrefix + "_" + name
+	}
+	s := syntheticSeries(name, 1, ts)
+	for n, v := range labels {
+		s.Labels = append(s.Labels, prompb.Label{Name: n, Value: v})
+	}
+	sortLabels(s.Labels)
+	return s
+}
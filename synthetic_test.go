@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// seriesByName returns the series of samples named name.
+func seriesByName(samples []prompb.TimeSeries, name string) []prompb.TimeSeries {
+	var out []prompb.TimeSeries
+	for _, s := range samples {
+		if labelValue(s.Labels, "__name__") == name {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func TestScrapeSampleStats(t *testing.T) {
+	defer setInstance("test-instance")()
+	defer func(prev bool) { syntheticMetrics = prev }(syntheticMetrics)
+	defer func(prev bool) { emitTargetInfo = prev }(emitTargetInfo)
+	defer func(prev int) { maxTotalSeries = prev }(maxTotalSeries)
+	syntheticMetrics = true
+	emitTargetInfo = false
+	maxTotalSeries = 3
+
+	r := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_filtered", Help: "test"}, []string{"i"})
+	r.MustRegister(g)
+	for i := 0; i < 5; i++ {
+		g.WithLabelValues(fmt.Sprint(i)).Set(float64(i))
+	}
+
+	samples, err := newPipeline(r, nil).collect(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]float64{
+		"scrape_samples_scraped":                5,
+		"scrape_samples_post_metric_relabeling": 3,
+	} {
+		got := seriesByName(samples, name)
+		if len(got) != 1 {
+			t.Errorf("got %d %s series, want 1", len(got), name)
+			continue
+		}
+		if v := got[0].Samples[0].Value; v != want {
+			t.Errorf("%s = %v, want %v", name, v, want)
+		}
+		if v := labelValue(got[0].Labels, instanceLabel); v != "test-instance" {
+			t.Errorf("%s has %s=%q", name, instanceLabel, v)
+		}
+	}
+}
+
+func TestTargetInfo(t *testing.T) {
+	r := prometheus.NewRegistry()
+	p := newPipeline(r, nil)
+
+	var first []prompb.Label
+	for i := 0; i < 2; i++ {
+		samples, err := p.collect(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info := seriesByName(samples, "target_info")
+		if len(info) != 1 {
+			t.Fatalf("got %d target_info series, want 1", len(info))
+		}
+		if v := info[0].Samples[0].Value; v != 1 {
+			t.Errorf("target_info = %v, want 1", v)
+		}
+		for _, name := range []string{"arch", "go_version", "host", "os", "version"} {
+			if labelValue(info[0].Labels, name) == "" {
+				t.Errorf("target_info has no %s label", name)
+			}
+		}
+		if first == nil {
+			first = info[0].Labels
+		} else if seriesKey(info[0].Labels) != seriesKey(first) {
+			t.Errorf("target_info labels changed from %v to %v", first, info[0].Labels)
+		}
+	}
+
+	defer func(prev bool) { emitTargetInfo = prev }(emitTargetInfo)
+	emitTargetInfo = false
+	samples, err := p.collect(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(seriesByName(samples, "target_info")); n != 0 {
+		t.Errorf("got %d target_info series with -emit-target-info=false", n)
+	}
+}
+
+func TestExternalLabelsInfo(t *testing.T) {
+	defer func(prev bool) { emitExternalLabelsInfo = prev }(emitExternalLabelsInfo)
+	emitExternalLabelsInfo = true
+
+	dir, err := ioutil.TempDir("", "external-labels-info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "labels.json")
+	writeLabelsFile(t, path, `{"cluster": "eu-1", "region": "eu"}`, time.Now())
+	f, err := newExternalLabelsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samples, err := newPipeline(prometheus.NewRegistry(), f).collect(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := seriesByName(samples, internalMetricsPrefix+"_external_labels_info")
+	if len(info) != 1 {
+		t.Fatalf("got %d info series, want 1", len(info))
+	}
+	if v := info[0].Samples[0].Value; v != 1 {
+		t.Errorf("info series = %v, want 1", v)
+	}
+	names := map[string]int{}
+	for _, l := range info[0].Labels {
+		names[l.Name]++
+	}
+	for name, want := range map[string]string{"cluster": "eu-1", "region": "eu"} {
+		if got := labelValue(info[0].Labels, name); got != want {
+			t.Errorf("info series has %s=%q, want %q", name, got, want)
+		}
+		if names[name] != 1 {
+			t.Errorf("info series has %d %s labels", names[name], name)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// teeBufferSize bounds how many write requests wait to be written to the tee
+// file before new ones are dropped.
+const teeBufferSize = 64
+
+var teeErrors = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "remote_write_tee_errors_total",
+	Help: "Write requests that couldn't be written to -tee-file",
+})
+
+// tee records the sent write requests with -tee-file. Nil disables it.
+var tee *teeWriter
+
+// teeWriter appends write requests to a file. Each record is the length of
+// the request as a uvarint followed by the request exactly as sent. The file
+// is written in the background, so it never holds up or fails a push.
+type teeWriter struct {
+	requests chan []byte
+}
+
+// teeDest is the file a teeWriter appends to.
+type teeDest interface {
+	io.Writer
+	Truncate(size int64) error
+}
+
+func newTeeWriter(path string) (*teeWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	t := &teeWriter{requests: make(chan []byte, teeBufferSize)}
+	go t.run(f, fi.Size())
+	return t, nil
+}
+
+// write queues req to be written to the file.
+func (t *teeWriter) write(req []byte) {
+	if t == nil {
+		return
+	}
+	select {
+	case t.requests <- req:
+	default:
+		log.Printf("tee file is falling behind, not writing a request of %d bytes", len(req))
+		teeErrors.Inc()
+	}
+}
+
+// run writes the queued requests to f, which is size bytes long. After a
+// failed write, f is truncated back to its last complete record, so a
+// partially written one can't break the framing of the records following
+// it. If that fails too, the tee is disabled.
+func (t *teeWriter) run(f teeDest, size int64) {
+	w := bufio.NewWriter(f)
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	var (
+		disabled      bool
+		buffered      int
+		bufferedBytes int64
+	)
+	for req := range t.requests {
+		if disabled {
+			teeErrors.Inc()
+			continue
+		}
+		n := binary.PutUvarint(lenBuf, uint64(len(req)))
+		_, err := w.Write(lenBuf[:n])
+		if err == nil {
+			_, err = w.Write(req)
+		}
+		buffered++
+		bufferedBytes += int64(n + len(req))
+		if err == nil && len(t.requests) == 0 {
+			if err = w.Flush(); err == nil {
+				size += bufferedBytes
+				buffered, bufferedBytes = 0, 0
+			}
+		}
+		if err == nil {
+			continue
+		}
+
+		log.Printf("writing to tee file: %v", err)
+		teeErrors.Add(float64(buffered))
+		buffered, bufferedBytes = 0, 0
+		if err := f.Truncate(size); err != nil {
+			log.Printf("disabling -tee-file, can't remove a partially written request: %v", err)
+			disabled = true
+			continue
+		}
+		w.Reset(f)
+	}
+}
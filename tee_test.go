@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	config_util "github.com/prometheus/common/config"
+)
+
+// readTeeRecords returns the write requests recorded in the format of
+// -tee-file, and whether r ended after a complete record.
+func readTeeRecords(r io.Reader) (reqs [][]byte, complete bool) {
+	br := bufio.NewReader(r)
+	for {
+		n, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return reqs, true
+		}
+		if err != nil {
+			return reqs, false
+		}
+		req := make([]byte, n)
+		if _, err := io.ReadFull(br, req); err != nil {
+			return reqs, false
+		}
+		reqs = append(reqs, req)
+	}
+}
+
+func TestTee(t *testing.T) {
+	defer func(prev *teeWriter, retries int, backoff time.Duration) {
+		tee, pushRetries, pushRetryBackoff = prev, retries, backoff
+	}(tee, pushRetries, pushRetryBackoff)
+	pushRetries, pushRetryBackoff = 2, time.Millisecond
+
+	// Every request fails once, to be sent by its retry.
+	var (
+		mu       sync.Mutex
+		seen     = map[string]bool{}
+		received [][]byte
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !seen[string(body)] {
+			seen[string(body)] = true
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		received = append(received, body)
+	}))
+	defer receiver.Close()
+	cl, err := newHTTPWriteClient(receiver.URL, config_util.HTTPClientConfig{}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "tee")
+	if tee, err = newTeeWriter(path); err != nil {
+		t.Fatal(err)
+	}
+	var batches []batch
+	for i := 0; i < 3; i++ {
+		b, err := newBatch(testSeries(i+1, fmt.Sprintf("tee_%d", i)), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		batches = append(batches, b)
+	}
+	p := newPipeline(prometheus.NewRegistry(), nil)
+	if pending := sendBatches(context.Background(), cl, p, nil, batches, time.Now().Add(time.Minute), make(chan struct{})); len(pending) != 0 {
+		t.Fatalf("%d batches left pending", len(pending))
+	}
+
+	var teed [][]byte
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if teed, _ = readTeeRecords(bytes.NewReader(data)); len(teed) >= len(batches) {
+			break
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != len(batches) {
+		t.Fatalf("receiver got %d of %d requests", len(received), len(batches))
+	}
+	// Failed attempts aren't recorded, so each request is in the file once.
+	if !reflect.DeepEqual(teed, received) {
+		t.Errorf("file holds %d requests, want the %d the receiver got", len(teed), len(received))
+	}
+}
+
+// flakyFile is a teeDest in memory whose failWrite-th write fails after
+// writing half of its data.
+type flakyFile struct {
+	data        []byte
+	failWrite   int
+	writes      int
+	truncateErr error
+}
+
+func (f *flakyFile) Write(p []byte) (int, error) {
+	f.writes++
+	if f.writes == f.failWrite {
+		f.data = append(f.data, p[:len(p)/2]...)
+		return len(p) / 2, errors.New("no space left on device")
+	}
+	f.data = append(f.data, p...)
+	return len(p), nil
+}
+
+func (f *flakyFile) Truncate(size int64) error {
+	if f.truncateErr != nil {
+		return f.truncateErr
+	}
+	f.data = f.data[:size]
+	return nil
+}
+
+// teeTo writes reqs one after another to f through a teeWriter.
+func teeTo(f teeDest, reqs ...[]byte) {
+	t := &teeWriter{requests: make(chan []byte)}
+	done := make(chan struct{})
+	go func() {
+		t.run(f, 0)
+		close(done)
+	}()
+	for _, req := range reqs {
+		t.requests <- req
+	}
+	close(t.requests)
+	<-done
+}
+
+func TestTeeWriteErrors(t *testing.T) {
+	reqs := [][]byte{bytes.Repeat([]byte("a"), 10), bytes.Repeat([]byte("b"), 20), bytes.Repeat([]byte("c"), 30)}
+
+	// The half written request is cut off, the next one is written after
+	// the last complete one.
+	f := &flakyFile{failWrite: 2}
+	errs := metricValue(teeErrors)
+	teeTo(f, reqs...)
+	got, complete := readTeeRecords(bytes.NewReader(f.data))
+	if !complete || !reflect.DeepEqual(got, [][]byte{reqs[0], reqs[2]}) {
+		t.Errorf("file holds %q (complete: %v), want the first and last requests", got, complete)
+	}
+	if got := metricValue(teeErrors) - errs; got != 1 {
+		t.Errorf("counted %v tee errors, want 1", got)
+	}
+
+	// A file that can't be repaired isn't written to anymore.
+	f = &flakyFile{failWrite: 2, truncateErr: errors.New("read-only file system")}
+	errs = metricValue(teeErrors)
+	teeTo(f, reqs...)
+	if got, _ := readTeeRecords(bytes.NewReader(f.data)); !reflect.DeepEqual(got, reqs[:1]) {
+		t.Errorf("file holds %q, want only the first request", got)
+	}
+	if f.writes != 2 {
+		t.Errorf("got %d writes, want none after the failed one", f.writes)
+	}
+	if got := metricValue(teeErrors) - errs; got != 2 {
+		t.Errorf("counted %v tee errors, want 2", got)
+	}
+}
+
+func TestTeeFileErrors(t *testing.T) {
+	if _, err := newTeeWriter(filepath.Join(t.TempDir(), "missing", "tee")); err == nil {
+		t.Error("got no error opening a file in a missing directory")
+	}
+
+	// A file falling behind only counts the request it couldn't write.
+	full := &teeWriter{requests: make(chan []byte)}
+	errs := metricValue(teeErrors)
+	full.write([]byte("req"))
+	if got := metricValue(teeErrors) - errs; got != 1 {
+		t.Errorf("counted %v tee errors, want 1", got)
+	}
+
+	// Without -tee-file, nothing is written.
+	var disabled *teeWriter
+	disabled.write([]byte("req"))
+}
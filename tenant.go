@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const tenantPlaceholder = "{tenant}"
+
+// expandTenantURL replaces the {tenant} placeholder of a remote write URL
+// template with the tenant ID. A template requires a tenant and a tenant
+// requires a template, as the tenant can't be conveyed otherwise.
+func expandTenantURL(template, tenant string) (string, error) {
+	hasPlaceholder := strings.Contains(template, tenantPlaceholder)
+	switch {
+	case hasPlaceholder && tenant == "":
+		return "", fmt.Errorf("remote write URL %q contains %s but no tenant ID is configured", template, tenantPlaceholder)
+	case !hasPlaceholder && tenant != "":
+		return "", fmt.Errorf("tenant ID %q is configured but remote write URL %q has no %s placeholder", tenant, template, tenantPlaceholder)
+	}
+	return strings.Replace(template, tenantPlaceholder, url.PathEscape(tenant), -1), nil
+}
+
+// tenantSeries holds the series belonging to one tenant.
+type tenantSeries struct {
+	tenant string
+	series []prompb.TimeSeries
+}
+
+// splitByTenant groups series by the value of the tenant label, in tenant
+// order. Series without the label belong to defaultTenant.
+func splitByTenant(series []prompb.TimeSeries, label, defaultTenant string) []tenantSeries {
+	groups := map[string][]prompb.TimeSeries{}
+	for _, s := range series {
+		tenant := defaultTenant
+		for _, l := range s.Labels {
+			if l.Name == label {
+				tenant = l.Value
+				break
+			}
+		}
+		groups[tenant] = append(groups[tenant], s)
+	}
+
+	tenants := make([]tenantSeries, 0, len(groups))
+	for tenant, series := range groups {
+		tenants = append(tenants, tenantSeries{tenant: tenant, series: series})
+	}
+	sort.Slice(tenants, func(i, j int) bool {
+		return tenants[i].tenant < tenants[j].tenant
+	})
+	return tenants
+}
+
+// withoutLabel returns copies of series without the named label. The series
+// themselves are left alone, as they're still tracked under their full
+// label set. An empty name copies the series as they are.
+func withoutLabel(series []prompb.TimeSeries, name string) []prompb.TimeSeries {
+	out := make([]prompb.TimeSeries, len(series))
+	for i, s := range series {
+		labels := make([]prompb.Label, 0, len(s.Labels))
+		for _, l := range s.Labels {
+			if l.Name != name {
+				labels = append(labels, l)
+			}
+		}
+		out[i] = prompb.TimeSeries{Labels: labels, Samples: s.Samples}
+	}
+	return out
+}
+
+type tenantKey struct{}
+
+// withTenant sets the tenant the write request sent with ctx belongs to.
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+func requestTenant(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
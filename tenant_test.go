@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestExpandTenantURL(t *testing.T) {
+	for _, c := range []struct {
+		template, tenant string
+		want             string
+		wantErr          bool
+	}{
+		{"http://gw/api/v1/push/{tenant}", "team-a", "http://gw/api/v1/push/team-a", false},
+		{"http://gw/{tenant}/push", "a/b c", "http://gw/a%2Fb%20c/push", false},
+		{"http://gw/api/v1/push", "", "http://gw/api/v1/push", false},
+		{"http://gw/api/v1/push/{tenant}", "", "", true},
+		{"http://gw/api/v1/push", "team-a", "", true},
+	} {
+		got, err := expandTenantURL(c.template, c.tenant)
+		if (err != nil) != c.wantErr {
+			t.Errorf("expandTenantURL(%q, %q): got error %v", c.template, c.tenant, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("expandTenantURL(%q, %q) = %q, want %q", c.template, c.tenant, got, c.want)
+		}
+	}
+}
+
+func TestPerTenantRequests(t *testing.T) {
+	defer func(label, header string) { tenantLabel, tenantHeader = label, header }(tenantLabel, tenantHeader)
+	tenantLabel = "namespace"
+	tenantHeader = "X-Scope-OrgID"
+	defer func(prev bool) { emitTargetInfo = prev }(emitTargetInfo)
+	emitTargetInfo = false
+
+	r := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_tenanted", Help: "test"}, []string{"namespace", "pod"})
+	r.MustRegister(g)
+	g.WithLabelValues("team-a", "a1").Set(1)
+	g.WithLabelValues("team-a", "a2").Set(2)
+	g.WithLabelValues("team-b", "b1").Set(3)
+	r.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_untenanted", Help: "test"}))
+
+	receiver := newTestReceiver()
+	defer receiver.Close()
+	cl, err := newHTTPWriteClient(receiver.URL+"/write", config_util.HTTPClientConfig{}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newPipeline(r, nil)
+	batches, err := nextBatches(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendBatches(context.Background(), cl, p, nil, batches, time.Now().Add(time.Minute), make(chan struct{}))
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	var tenants []string
+	for _, h := range receiver.headers {
+		tenants = append(tenants, h.Get(tenantHeader))
+	}
+	if want := []string{defaultTenant, "team-a", "team-b"}; !reflect.DeepEqual(tenants, want) {
+		t.Errorf("got requests for tenants %v, want %v", tenants, want)
+	}
+	for _, b := range batches {
+		for _, s := range b.samples {
+			tenant := labelValue(s.Labels, tenantLabel)
+			if tenant == "" {
+				tenant = defaultTenant
+			}
+			if tenant != b.tenant {
+				t.Errorf("series %s sent in the batch of %s", seriesKey(s.Labels), b.tenant)
+			}
+		}
+	}
+}
+
+func TestInternalTenantLabelNotSent(t *testing.T) {
+	defer func(prev string) { tenantLabel = prev }(tenantLabel)
+	tenantLabel = "__tenant__"
+
+	samples := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "m"}, {Name: "__tenant__", Value: "team-a"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}
+	groups := splitByTenant(samples, tenantLabel, defaultTenant)
+	if len(groups) != 1 || groups[0].tenant != "team-a" {
+		t.Fatalf("got groups %v", groups)
+	}
+	b, err := newBatch(groups[0].series, groups[0].tenant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := labelValue(decodeWriteRequest(t, b.data).Timeseries[0].Labels, "__tenant__"); v != "" {
+		t.Errorf("internal tenant label was sent as %q", v)
+	}
+	if v := labelValue(b.samples[0].Labels, "__tenant__"); v != "team-a" {
+		t.Errorf("collected series lost the tenant label")
+	}
+
+	// The series are verified as the receiver got them.
+	receiver := newTestReceiver()
+	defer receiver.Close()
+	cl, err := newHTTPWriteClient(receiver.URL+"/write", config_util.HTTPClientConfig{}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Store(context.Background(), b.data); err != nil {
+		t.Fatal(err)
+	}
+	p := &pipeline{verifier: newWriteVerifier(newTestReader(t, receiver.URL+"/read"), 0, 1)}
+	before := metricValue(verificationFailures)
+	p.sent(b.samples, b.wire)
+	waitVerified(t, p.verifier)
+	if got := metricValue(verificationFailures) - before; got != 0 {
+		t.Errorf("counted %v verification failures", got)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+var truncatedLabelValues = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "remote_write_truncated_label_values_total",
+	Help: "Label values truncated to -wire-max-label-value-bytes",
+})
+
+// truncateLabelValues shortens every label value longer than maxBytes to
+// maxBytes, ending it with marker so the cut is visible, and returns how many
+// it shortened. The labels are changed in place, so series must be a copy
+// of the collected series.
+func truncateLabelValues(series []prompb.TimeSeries, maxBytes int, marker string) int {
+	truncated := 0
+	for i := range series {
+		for j := range series[i].Labels {
+			l := &series[i].Labels[j]
+			if len(l.Value) <= maxBytes {
+				continue
+			}
+			l.Value = truncateString(l.Value, maxBytes, marker)
+			truncated++
+		}
+	}
+	return truncated
+}
+
+// truncateString cuts s to at most maxBytes including marker, without
+// splitting a UTF-8 sequence.
+func truncateString(s string, maxBytes int, marker string) string {
+	if len(marker) > maxBytes {
+		marker = ""
+	}
+	n := maxBytes - len(marker)
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + marker
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestTruncateString(t *testing.T) {
+	for _, c := range []struct {
+		s        string
+		maxBytes int
+		marker   string
+		want     string
+	}{
+		{"abcdefghij", 8, "...", "abcde..."},
+		{"abcdefghij", 8, "", "abcdefgh"},
+		{"abcdefghij", 2, "...", "ab"},
+		// é takes two bytes and isn't split.
+		{"aééé", 6, "..", "aé.."},
+	} {
+		if got := truncateString(c.s, c.maxBytes, c.marker); got != c.want {
+			t.Errorf("truncateString(%q, %d, %q) = %q, want %q", c.s, c.maxBytes, c.marker, got, c.want)
+		}
+	}
+}
+
+func TestWireMaxLabelValueBytes(t *testing.T) {
+	defer func(prev int) { wireMaxLabelValueBytes = prev }(wireMaxLabelValueBytes)
+	wireMaxLabelValueBytes = 16
+	long := strings.Repeat("x", 100)
+
+	samples := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "m"}, {Name: "long", Value: long}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}
+	before := metricValue(truncatedLabelValues)
+	for i := 0; i < 2; i++ {
+		b, err := newBatch(samples, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := labelValue(decodeWriteRequest(t, b.data).Timeseries[0].Labels, "long")
+		if want := strings.Repeat("x", 13) + "..."; got != want {
+			t.Errorf("push %d sent %q, want %q", i, got, want)
+		}
+	}
+	if v := labelValue(samples[0].Labels, "long"); v != long {
+		t.Errorf("collected series was truncated to %q", v)
+	}
+	if got := metricValue(truncatedLabelValues) - before; got != 2 {
+		t.Errorf("counted %v truncations, want 2", got)
+	}
+}
+
+func TestWireTruncationLeavesCollectedSeries(t *testing.T) {
+	defer func(maxBytes, abort int) {
+		wireMaxLabelValueBytes, abortIfRequestExceeds = maxBytes, abort
+	}(wireMaxLabelValueBytes, abortIfRequestExceeds)
+	wireMaxLabelValueBytes = 16
+	// Small enough for the size check to build the request of every series.
+	abortIfRequestExceeds = 1000
+	long := strings.Repeat("x", 5000)
+
+	r := prometheus.NewRegistry()
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_truncated", Help: "test"}, []string{"long"})
+	r.MustRegister(g)
+	g.WithLabelValues(long).Set(1)
+	p := newPipeline(r, nil)
+
+	before := metricValue(truncatedLabelValues)
+	samples, err := p.collect(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := seriesByName(samples, "test_truncated"); len(got) != 1 || labelValue(got[0].Labels, "long") != long {
+		t.Fatalf("dry run collected %v, want the series with its full label value", got)
+	}
+	if got := metricValue(truncatedLabelValues) - before; got != 0 {
+		t.Errorf("dry run counted %v truncations", got)
+	}
+
+	samples, err = p.collect(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := seriesByName(samples, "test_truncated"); len(got) != 1 || labelValue(got[0].Labels, "long") != long {
+		t.Fatalf("collected %v, want the series with its full label value", got)
+	}
+	if _, err := newBatch(samples, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := metricValue(truncatedLabelValues) - before; got != 1 {
+		t.Errorf("counted %v truncations for one push, want 1", got)
+	}
+}
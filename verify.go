@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+var verificationFailures = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "remote_write_verification_failures_total",
+	Help: "Written samples that could not be read back from the receiver as sent",
+})
+
+// writeVerifier periodically reads back a few of the series that were just
+// written, to check the receiver really stored them.
+type writeVerifier struct {
+	reader *remote.Client
+	every  time.Duration
+	series int
+
+	mu      sync.Mutex
+	last    time.Time
+	running bool
+}
+
+func newWriteVerifier(reader *remote.Client, every time.Duration, series int) *writeVerifier {
+	return &writeVerifier{
+		reader: reader,
+		every:  every,
+		series: series,
+	}
+}
+
+// written is called with the series of every successful push. At most once
+// per interval it checks a random subset of them in the background.
+func (v *writeVerifier) written(samples []prompb.TimeSeries) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.running || time.Since(v.last) < v.every || len(samples) == 0 {
+		return
+	}
+	v.last = time.Now()
+	v.running = true
+
+	picked := make([]prompb.TimeSeries, 0, v.series)
+	for _, i := range rand.Perm(len(samples)) {
+		if len(picked) == v.series {
+			break
+		}
+		if len(samples[i].Samples) > 0 {
+			picked = append(picked, samples[i])
+		}
+	}
+
+	go func() {
+		for _, s := range picked {
+			if err := v.verify(s); err != nil {
+				log.Printf("write verification of %s failed: %v", seriesKey(s.Labels), err)
+				verificationFailures.Inc()
+			}
+		}
+
+		v.mu.Lock()
+		v.running = false
+		v.mu.Unlock()
+	}()
+}
+
+// verify reads the newest sample of s back and compares it to what was sent.
+func (v *writeVerifier) verify(s prompb.TimeSeries) error {
+	want := s.Samples[len(s.Samples)-1]
+	query := &prompb.Query{
+		StartTimestampMs: want.Timestamp,
+		EndTimestampMs:   want.Timestamp,
+	}
+	for _, l := range s.Labels {
+		query.Matchers = append(query.Matchers, &prompb.LabelMatcher{
+			Type:  prompb.LabelMatcher_EQ,
+			Name:  l.Name,
+			Value: l.Value,
+		})
+	}
+
+	res, err := v.reader.Read(context.Background(), query)
+	if err != nil {
+		return err
+	}
+	for _, ts := range res.Timeseries {
+		for _, got := range ts.Samples {
+			if got.Timestamp != want.Timestamp {
+				continue
+			}
+			if got.Value == want.Value || (math.IsNaN(got.Value) && math.IsNaN(want.Value)) {
+				return nil
+			}
+			return fmt.Errorf("read back value %v at %d, sent %v", got.Value, got.Timestamp, want.Value)
+		}
+	}
+	return fmt.Errorf("sample at %d not found", want.Timestamp)
+}
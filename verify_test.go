@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+// testReceiver stores the series written to /write and serves them on
+// /read, matching label equality only.
+type testReceiver struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	series  map[string]prompb.TimeSeries
+	headers []http.Header
+}
+
+func newTestReceiver() *testReceiver {
+	r := &testReceiver{series: map[string]prompb.TimeSeries{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/write", r.write)
+	mux.HandleFunc("/read", r.read)
+	r.Server = httptest.NewServer(mux)
+	return r
+}
+
+func decodeBody(req *http.Request, pb proto.Message) error {
+	compressed, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, pb)
+}
+
+func (r *testReceiver) write(w http.ResponseWriter, req *http.Request) {
+	var wr prompb.WriteRequest
+	if err := decodeBody(req, &wr); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.headers = append(r.headers, req.Header)
+	for _, s := range wr.Timeseries {
+		key := seriesKey(s.Labels)
+		stored := r.series[key]
+		stored.Labels = s.Labels
+		stored.Samples = append(stored.Samples, s.Samples...)
+		r.series[key] = stored
+	}
+}
+
+func (r *testReceiver) read(w http.ResponseWriter, req *http.Request) {
+	var rr prompb.ReadRequest
+	if err := decodeBody(req, &rr); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var resp prompb.ReadResponse
+	for _, q := range rr.Queries {
+		result := &prompb.QueryResult{}
+		for _, s := range r.series {
+			if matches(s.Labels, q.Matchers) {
+				ts := s
+				result.Timeseries = append(result.Timeseries, &ts)
+			}
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	data, err := proto.Marshal(&resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(snappy.Encode(nil, data))
+}
+
+func matches(labels []prompb.Label, matchers []*prompb.LabelMatcher) bool {
+	for _, m := range matchers {
+		if labelValue(labels, m.Name) != m.Value {
+			return false
+		}
+	}
+	return true
+}
+
+func newTestReader(t *testing.T, rawURL string) *remote.Client {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := remote.NewClient(0, &remote.ClientConfig{
+		URL:              &config_util.URL{URL: u},
+		Timeout:          model.Duration(5 * time.Second),
+		HTTPClientConfig: config_util.HTTPClientConfig{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reader
+}
+
+// waitVerified waits for the background verification of v to finish.
+func waitVerified(t *testing.T, v *writeVerifier) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		v.mu.Lock()
+		running := v.running
+		v.mu.Unlock()
+		if !running {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("verification didn't finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWriteVerifier(t *testing.T) {
+	receiver := newTestReceiver()
+	defer receiver.Close()
+	cl, err := newHTTPWriteClient(receiver.URL+"/write", config_util.HTTPClientConfig{}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := newBatch(testSeries(5, "verified"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Store(context.Background(), b.data); err != nil {
+		t.Fatal(err)
+	}
+
+	v := newWriteVerifier(newTestReader(t, receiver.URL+"/read"), 0, 5)
+	before := metricValue(verificationFailures)
+	v.written(b.wire)
+	waitVerified(t, v)
+	if got := metricValue(verificationFailures) - before; got != 0 {
+		t.Errorf("counted %v verification failures for written series", got)
+	}
+
+	// A series the receiver never got fails verification.
+	before = metricValue(verificationFailures)
+	v.written(testSeries(1, "lost"))
+	waitVerified(t, v)
+	if got := metricValue(verificationFailures) - before; got != 1 {
+		t.Errorf("counted %v verification failures for a lost series, want 1", got)
+	}
+}